@@ -0,0 +1,215 @@
+package mylar
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromy/mylar/internal/features/api"
+	"github.com/chromy/mylar/internal/features/index"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"image"
+	"image/color"
+	"image/color/palette"
+	"image/draw"
+	"image/gif"
+	"image/png"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+)
+
+type AnimateOptions struct {
+	Repo       string
+	Committish string
+	Layer      string
+	Params     map[string]string
+	Agg        string
+	Channel    int
+	Colormap   string
+	Min        string
+	Max        string
+	Size       int
+	// Frames is the number of commits sampled along the first-parent
+	// history, or of tags if Tags is set.
+	Frames int
+	// Tags samples the tags reachable from Committish instead.
+	Tags  bool
+	Delay time.Duration
+	// Output is a .gif path or a directory to write numbered PNGs to.
+	Output string
+}
+
+type animationFrame struct {
+	commit plumbing.Hash
+	label  string
+	layout utils.TileLayout
+}
+
+// gifPalette is transparent, for the space outside smaller maps, then
+// a general purpose palette.
+var gifPalette = append(color.Palette{color.Transparent}, palette.Plan9[:255]...)
+
+// encodeGif writes frames as a looping GIF. Each frame is cleared to the
+// transparent background before the next is drawn, otherwise viewers
+// show earlier frames through its transparent pixels.
+func encodeGif(w io.Writer, frames []*image.NRGBA, delay time.Duration) error {
+	anim := &gif.GIF{BackgroundIndex: 0}
+	for _, frame := range frames {
+		paletted := image.NewPaletted(frame.Bounds(), gifPalette)
+		draw.Draw(paletted, frame.Bounds(), frame, image.Point{}, draw.Src)
+		anim.Image = append(anim.Image, paletted)
+		anim.Delay = append(anim.Delay, int(delay/(10*time.Millisecond)))
+		anim.Disposal = append(anim.Disposal, gif.DisposalBackground)
+	}
+	return gif.EncodeAll(w, anim)
+}
+
+func writeGif(path string, frames []*image.NRGBA, delay time.Duration) error {
+	f, err := os.Create(path)
+	if err != nil {
+		return err
+	}
+	if err := encodeGif(f, frames, delay); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
+
+func writePngs(dir string, frames []*image.NRGBA) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	for i, frame := range frames {
+		f, err := os.Create(filepath.Join(dir, fmt.Sprintf("frame-%04d.png", i+1)))
+		if err != nil {
+			return err
+		}
+		if err := png.Encode(f, frame); err != nil {
+			f.Close()
+			return err
+		}
+		if err := f.Close(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// DoAnimate renders the map of a layer at a series of commits. Every
+// frame is drawn in the world of the largest commit and, unless Min and
+// Max are given, with the union of the value ranges so frames are
+// comparable.
+func DoAnimate(ctx context.Context, options AnimateOptions) error {
+	repoId, err := openRepo(ctx, options.Repo)
+	if err != nil {
+		return err
+	}
+
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return err
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, options.Committish)
+	if err != nil {
+		return err
+	}
+
+	timeline, err := index.GetTimeline(ctx, repoId, commit, options.Frames, options.Tags)
+	if err != nil {
+		return err
+	}
+
+	var frames []animationFrame
+	var worldSide int64
+	for _, point := range timeline.Points {
+		// There is nothing to draw before the first file is added.
+		if point.Stats.Files == 0 {
+			continue
+		}
+		hash := plumbing.NewHash(point.Commit)
+		layout, err := index.GetLayoutForCommit(ctx, repoId, hash)
+		if err != nil {
+			return err
+		}
+		label := point.Tag
+		if label == "" {
+			label = point.Commit[:12]
+		}
+		frames = append(frames, animationFrame{commit: hash, label: label, layout: layout})
+		worldSide = max(worldSide, layout.GridSideLength())
+	}
+	if len(frames) == 0 {
+		if options.Tags {
+			return fmt.Errorf("no tags with files reachable from %s", options.Committish)
+		}
+		return fmt.Errorf("no commits with files reachable from %s", options.Committish)
+	}
+
+	renderOptions := func(frame animationFrame) api.MapRenderOptions {
+		return api.MapRenderOptions{
+			RepoId:      repoId,
+			Commit:      frame.commit,
+			Computation: options.Layer,
+			Params:      options.Params,
+			Agg:         options.Agg,
+			Channel:     options.Channel,
+			Colormap:    options.Colormap,
+			Min:         options.Min,
+			Max:         options.Max,
+			Layout:      frame.layout,
+			WorldSide:   worldSide,
+			Size:        options.Size,
+		}
+	}
+
+	if options.Min == "" || options.Max == "" {
+		var lo, hi int32
+		found := false
+		for _, frame := range frames {
+			r, ok, err := api.MapValueRange(ctx, renderOptions(frame))
+			if err != nil {
+				return fmt.Errorf("%s: %w", frame.label, err)
+			}
+			if !ok {
+				continue
+			}
+			if !found || r.Min < lo {
+				lo = r.Min
+			}
+			if !found || r.Max > hi {
+				hi = r.Max
+			}
+			found = true
+		}
+		if found {
+			if options.Min == "" {
+				options.Min = strconv.FormatInt(int64(lo), 10)
+			}
+			if options.Max == "" {
+				options.Max = strconv.FormatInt(int64(hi), 10)
+			}
+		}
+	}
+
+	images := make([]*image.NRGBA, 0, len(frames))
+	for i, frame := range frames {
+		log.Printf("rendering frame %d/%d: %s at %s", i+1, len(frames), options.Layer, frame.label)
+		img, err := api.RenderMap(ctx, renderOptions(frame))
+		if err != nil {
+			return fmt.Errorf("%s: %w", frame.label, err)
+		}
+		images = append(images, img)
+	}
+
+	if strings.EqualFold(filepath.Ext(options.Output), ".gif") {
+		return writeGif(options.Output, images, options.Delay)
+	}
+	return writePngs(options.Output, images)
+}
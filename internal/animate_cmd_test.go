@@ -0,0 +1,57 @@
+package mylar
+
+import (
+	"bytes"
+	"image"
+	"image/color"
+	"image/gif"
+	"testing"
+	"time"
+)
+
+func TestEncodeGif(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+
+	// The first frame is filled, the second only has its top left pixel
+	// so the rest must not show the first frame.
+	first := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	for y := 0; y < 2; y++ {
+		for x := 0; x < 2; x++ {
+			first.SetNRGBA(x, y, red)
+		}
+	}
+	second := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	second.SetNRGBA(0, 0, red)
+
+	var buf bytes.Buffer
+	if err := encodeGif(&buf, []*image.NRGBA{first, second}, 250*time.Millisecond); err != nil {
+		t.Fatalf("encodeGif failed: %v", err)
+	}
+
+	anim, err := gif.DecodeAll(&buf)
+	if err != nil {
+		t.Fatalf("DecodeAll failed: %v", err)
+	}
+	if len(anim.Image) != 2 {
+		t.Fatalf("Expected 2 frames, got %d", len(anim.Image))
+	}
+	for i := range anim.Image {
+		if anim.Disposal[i] != gif.DisposalBackground {
+			t.Errorf("Frame %d: expected background disposal, got %d", i, anim.Disposal[i])
+		}
+		if anim.Delay[i] != 25 {
+			t.Errorf("Frame %d: expected a delay of 25, got %d", i, anim.Delay[i])
+		}
+	}
+
+	frame := anim.Image[1]
+	if _, _, _, a := frame.At(0, 0).RGBA(); a == 0 {
+		t.Errorf("Expected the top left pixel of the second frame to be opaque")
+	}
+	if _, _, _, a := frame.At(1, 1).RGBA(); a != 0 {
+		t.Errorf("Expected the rest of the second frame to be transparent")
+	}
+	if _, _, _, a := frame.Palette[anim.BackgroundIndex].RGBA(); a != 0 {
+		t.Errorf("Expected a transparent background, got %v", frame.Palette[anim.BackgroundIndex])
+	}
+}
@@ -0,0 +1,98 @@
+package cache
+
+import (
+	"bytes"
+	"compress/flate"
+	"fmt"
+	"io"
+	"sync"
+)
+
+// Values stored by CompressedCache are prefixed with a single byte
+// saying how the rest of the value is encoded.
+const (
+	encodingRaw   byte = 0
+	encodingFlate byte = 1
+)
+
+// minCompressSize is the smallest value worth compressing.
+const minCompressSize = 64
+
+var flateWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, err := flate.NewWriter(nil, flate.BestSpeed)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	},
+}
+
+// CompressedCache wraps another Cache compressing values on the way in
+// and decompressing them on the way out. Values which don't shrink are
+// stored as is.
+type CompressedCache struct {
+	inner Cache
+}
+
+// NewCompressedCache creates a cache which stores compressed values in
+// inner.
+func NewCompressedCache(inner Cache) *CompressedCache {
+	return &CompressedCache{
+		inner: inner,
+	}
+}
+
+// Add compresses value and stores it in the inner cache.
+func (c *CompressedCache) Add(key string, value []byte) error {
+	return c.inner.Add(key, compress(value))
+}
+
+// Get retrieves and decompresses a value from the inner cache.
+func (c *CompressedCache) Get(key string) ([]byte, error) {
+	stored, err := c.inner.Get(key)
+	if err != nil {
+		return nil, err
+	}
+	return decompress(stored)
+}
+
+func compress(value []byte) []byte {
+	if len(value) >= minCompressSize {
+		var buf bytes.Buffer
+		buf.WriteByte(encodingFlate)
+
+		w := flateWriterPool.Get().(*flate.Writer)
+		w.Reset(&buf)
+		_, writeErr := w.Write(value)
+		closeErr := w.Close()
+		flateWriterPool.Put(w)
+
+		if writeErr == nil && closeErr == nil && buf.Len() < len(value)+1 {
+			return buf.Bytes()
+		}
+	}
+
+	raw := make([]byte, 0, len(value)+1)
+	raw = append(raw, encodingRaw)
+	return append(raw, value...)
+}
+
+func decompress(stored []byte) ([]byte, error) {
+	if len(stored) == 0 {
+		return nil, fmt.Errorf("cache: empty compressed value")
+	}
+
+	switch stored[0] {
+	case encodingRaw:
+		// Copy so the value is aligned for callers which reinterpret
+		// it (e.g. as []int32).
+		return append([]byte(nil), stored[1:]...), nil
+	case encodingFlate:
+		r := flate.NewReader(bytes.NewReader(stored[1:]))
+		defer r.Close()
+		return io.ReadAll(r)
+	default:
+		return nil, fmt.Errorf("cache: unknown value encoding %d", stored[0])
+	}
+}
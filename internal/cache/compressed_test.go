@@ -0,0 +1,80 @@
+package cache
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestCompressedCache_AddAndGet(t *testing.T) {
+	tests := []struct {
+		name  string
+		value []byte
+	}{
+		{"Empty", []byte{}},
+		{"Small", []byte("small")},
+		{"Repetitive", bytes.Repeat([]byte{0, 0, 0, 0, 1, 2, 3, 4}, 2048)},
+		{"Incompressible", func() []byte {
+			value := make([]byte, 256)
+			for i := range value {
+				value[i] = byte(i*7919 + i*i)
+			}
+			return value
+		}()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			cache := NewCompressedCache(NewMemoryCache())
+
+			if err := cache.Add("key", tt.value); err != nil {
+				t.Fatalf("Add failed: %v", err)
+			}
+
+			retrieved, err := cache.Get("key")
+			if err != nil {
+				t.Fatalf("Get failed: %v", err)
+			}
+
+			if !bytes.Equal(retrieved, tt.value) {
+				t.Errorf("Expected %d bytes to round trip, got %d bytes", len(tt.value), len(retrieved))
+			}
+		})
+	}
+}
+
+func TestCompressedCache_CompressesRepetitiveValues(t *testing.T) {
+	inner := NewMemoryCache()
+	cache := NewCompressedCache(inner)
+
+	value := make([]byte, 64*64*4)
+	cache.Add("tile", value)
+
+	stored, err := inner.Get("tile")
+	if err != nil {
+		t.Fatalf("Get failed: %v", err)
+	}
+
+	if len(stored) >= len(value)/10 {
+		t.Errorf("Expected blank tile to compress at least 10x, got %d bytes from %d", len(stored), len(value))
+	}
+}
+
+func TestCompressedCache_GetNonExistent(t *testing.T) {
+	cache := NewCompressedCache(NewMemoryCache())
+
+	_, err := cache.Get("non_existent_key")
+	if err != ErrNotFound {
+		t.Errorf("Expected ErrNotFound, got %v", err)
+	}
+}
+
+func TestCompressedCache_UnknownEncoding(t *testing.T) {
+	inner := NewMemoryCache()
+	cache := NewCompressedCache(inner)
+
+	inner.Add("bad", []byte{42, 1, 2, 3})
+
+	if _, err := cache.Get("bad"); err == nil {
+		t.Error("Expected error for unknown encoding")
+	}
+}
@@ -6,9 +6,12 @@ import (
 	"flag"
 	"fmt"
 	_ "github.com/chromy/mylar/internal/features"
+	"github.com/chromy/mylar/internal/features/warm"
 	"github.com/chromy/mylar/internal/schemas"
 	"io/fs"
 	"os"
+	"strings"
+	"time"
 )
 
 //go:embed static/*
@@ -76,6 +79,168 @@ func Cmd() {
 		return 0
 	}
 
+	render := func(args []string) int {
+		fs := flag.NewFlagSet("render", flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "mylar render [flags] <repo path or gh:owner:name>\n")
+			fs.PrintDefaults()
+		}
+		committish := fs.String("committish", "HEAD", "commit, branch or tag to render")
+		layer := fs.String("layer", "length", "tile computation to render")
+		size := fs.Int("size", 1024, "width and height of the image in pixels")
+		output := fs.String("o", "mylar.png", "output PNG path")
+		agg := fs.String("agg", "", "aggregation used to build lower detail tiles, or one per channel separated by commas (default: mean)")
+		channel := fs.Int("channel", 0, "channel to render for multi-channel layers")
+		colormap := fs.String("colormap", "viridis", "colormap (viridis, categorical or diverging)")
+		min := fs.String("min", "", "value mapped to the start of the colormap (default: smallest value)")
+		max := fs.String("max", "", "value mapped to the end of the colormap (default: largest value)")
+		memcached := fs.String("memcached", os.Getenv("MYLAR_MEMCACHED"), "memcached address (e.g. localhost:8082)")
+		params := map[string]string{}
+		fs.Func("param", "layer parameter as name=value (repeatable)", func(s string) error {
+			name, value, found := strings.Cut(s, "=")
+			if !found {
+				return fmt.Errorf("expected name=value got %q", s)
+			}
+			params[name] = value
+			return nil
+		})
+
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 1
+		}
+
+		initCache(*memcached)
+		err := DoRender(ctx, RenderOptions{
+			Repo:       fs.Arg(0),
+			Committish: *committish,
+			Layer:      *layer,
+			Params:     params,
+			Agg:        *agg,
+			Channel:    *channel,
+			Colormap:   *colormap,
+			Min:        *min,
+			Max:        *max,
+			Size:       *size,
+			Output:     *output,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	animate := func(args []string) int {
+		fs := flag.NewFlagSet("animate", flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "mylar animate [flags] <repo path or gh:owner:name>\n")
+			fs.PrintDefaults()
+		}
+		committish := fs.String("committish", "HEAD", "last commit, branch or tag to animate")
+		layer := fs.String("layer", "length", "tile computation to render")
+		size := fs.Int("size", 512, "width and height of each frame in pixels")
+		frames := fs.Int("frames", 20, "number of commits (or tags with -tags) to sample")
+		tags := fs.Bool("tags", false, "sample tags instead of commits along the first-parent history")
+		delay := fs.Duration("delay", 500*time.Millisecond, "time each GIF frame is shown")
+		output := fs.String("o", "mylar.gif", "output GIF path, or a directory for numbered PNGs")
+		agg := fs.String("agg", "", "aggregation used to build lower detail tiles, or one per channel separated by commas (default: mean)")
+		channel := fs.Int("channel", 0, "channel to render for multi-channel layers")
+		colormap := fs.String("colormap", "viridis", "colormap (viridis, categorical or diverging)")
+		min := fs.String("min", "", "value mapped to the start of the colormap (default: smallest value in any frame)")
+		max := fs.String("max", "", "value mapped to the end of the colormap (default: largest value in any frame)")
+		memcached := fs.String("memcached", os.Getenv("MYLAR_MEMCACHED"), "memcached address (e.g. localhost:8082)")
+		params := map[string]string{}
+		fs.Func("param", "layer parameter as name=value (repeatable)", func(s string) error {
+			name, value, found := strings.Cut(s, "=")
+			if !found {
+				return fmt.Errorf("expected name=value got %q", s)
+			}
+			params[name] = value
+			return nil
+		})
+
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 1
+		}
+		if *frames < 1 {
+			fmt.Fprintf(os.Stderr, "error: frames must be positive\n")
+			return 1
+		}
+
+		initCache(*memcached)
+		err := DoAnimate(ctx, AnimateOptions{
+			Repo:       fs.Arg(0),
+			Committish: *committish,
+			Layer:      *layer,
+			Params:     params,
+			Agg:        *agg,
+			Channel:    *channel,
+			Colormap:   *colormap,
+			Min:        *min,
+			Max:        *max,
+			Size:       *size,
+			Frames:     *frames,
+			Tags:       *tags,
+			Delay:      *delay,
+			Output:     *output,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
+	warmCmd := func(args []string) int {
+		fs := flag.NewFlagSet("warm", flag.ExitOnError)
+		fs.Usage = func() {
+			fmt.Fprintf(os.Stderr, "mylar warm [flags] <repo path or gh:owner:name>\n")
+			fs.PrintDefaults()
+		}
+		committish := fs.String("committish", "HEAD", "commit, branch or tag to warm")
+		layers := fs.String("layers", "", "comma separated layers as computation[:agg] (default: every viewer layer)")
+		parallelism := fs.Int("parallelism", warm.DefaultParallelism, "number of tiles to compute at once")
+		memcached := fs.String("memcached", os.Getenv("MYLAR_MEMCACHED"), "memcached address to warm (required, e.g. localhost:8082)")
+
+		if err := fs.Parse(args); err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		if fs.NArg() != 1 {
+			fs.Usage()
+			return 1
+		}
+		// The in-memory cache is gone when we exit so warming it is
+		// pointless.
+		if *memcached == "" {
+			fmt.Fprintf(os.Stderr, "error: --memcached or MYLAR_MEMCACHED must be set\n")
+			return 1
+		}
+
+		initCache(*memcached)
+		err := DoWarm(ctx, WarmOptions{
+			Repo:        fs.Arg(0),
+			Committish:  *committish,
+			Layers:      *layers,
+			Parallelism: *parallelism,
+		})
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "error: %s\n", err)
+			return 1
+		}
+		return 0
+	}
+
 	main := func(args []string) int {
 		cmd := args[1]
 		subArgs := args[2:]
@@ -88,6 +253,12 @@ func Cmd() {
 			return dev(subArgs)
 		case "schemas":
 			return schemasCmd(subArgs)
+		case "render":
+			return render(subArgs)
+		case "animate":
+			return animate(subArgs)
+		case "warm":
+			return warmCmd(subArgs)
 		default:
 			fmt.Fprintf(os.Stderr, "Unknown subcommand '%s'\n", cmd)
 			Usage()
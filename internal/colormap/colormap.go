@@ -0,0 +1,152 @@
+package colormap
+
+import (
+	"image"
+	"image/color"
+	"math"
+	"sort"
+)
+
+// Range is the span of values mapped onto a colormap. Values outside
+// the range are clamped.
+type Range struct {
+	Min int32
+	Max int32
+}
+
+// Normalize maps value onto 0..1.
+func (r Range) Normalize(value int32) float64 {
+	if r.Max <= r.Min {
+		return 0.5
+	}
+	t := (float64(value) - float64(r.Min)) / (float64(r.Max) - float64(r.Min))
+	return math.Max(0, math.Min(1, t))
+}
+
+// AutoRange returns the range of the non-zero values. Zero is reserved
+// for empty pixels so it doesn't count.
+func AutoRange(values []int32) Range {
+	r := Range{Min: math.MaxInt32, Max: math.MinInt32}
+	for _, v := range values {
+		if v == 0 {
+			continue
+		}
+		r.Min = min(r.Min, v)
+		r.Max = max(r.Max, v)
+	}
+	if r.Min > r.Max {
+		return Range{Min: 0, Max: 1}
+	}
+	return r
+}
+
+// A Colormap maps a single pixel value onto a color.
+type Colormap func(value int32, r Range) color.NRGBA
+
+var colormaps = map[string]Colormap{
+	"viridis":     Viridis,
+	"categorical": Categorical,
+	"diverging":   Diverging,
+}
+
+// Get finds a colormap by name.
+func Get(name string) (Colormap, bool) {
+	cm, found := colormaps[name]
+	return cm, found
+}
+
+// Names lists the available colormaps.
+func Names() []string {
+	names := make([]string, 0, len(colormaps))
+	for name := range colormaps {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// interpolate linearly between evenly spaced stops.
+func interpolate(stops []color.NRGBA, t float64) color.NRGBA {
+	scaled := t * float64(len(stops)-1)
+	i := int(scaled)
+	if i >= len(stops)-1 {
+		return stops[len(stops)-1]
+	}
+	f := scaled - float64(i)
+	a, b := stops[i], stops[i+1]
+	lerp := func(x, y uint8) uint8 {
+		return uint8(math.Round(float64(x) + (float64(y)-float64(x))*f))
+	}
+	return color.NRGBA{R: lerp(a.R, b.R), G: lerp(a.G, b.G), B: lerp(a.B, b.B), A: 255}
+}
+
+var viridisStops = []color.NRGBA{
+	{0x44, 0x01, 0x54, 0xff},
+	{0x47, 0x2d, 0x7b, 0xff},
+	{0x3b, 0x52, 0x8b, 0xff},
+	{0x2c, 0x72, 0x8e, 0xff},
+	{0x21, 0x91, 0x8c, 0xff},
+	{0x28, 0xae, 0x80, 0xff},
+	{0x5e, 0xc9, 0x62, 0xff},
+	{0xad, 0xdc, 0x30, 0xff},
+	{0xfd, 0xe7, 0x25, 0xff},
+}
+
+// Viridis is a perceptually uniform sequential colormap.
+func Viridis(value int32, r Range) color.NRGBA {
+	return interpolate(viridisStops, r.Normalize(value))
+}
+
+var divergingStops = []color.NRGBA{
+	{0x3b, 0x4c, 0xc0, 0xff},
+	{0xf7, 0xf7, 0xf7, 0xff},
+	{0xb4, 0x04, 0x26, 0xff},
+}
+
+// Diverging runs from blue through white (the middle of the range) to
+// red.
+func Diverging(value int32, r Range) color.NRGBA {
+	return interpolate(divergingStops, r.Normalize(value))
+}
+
+// categoricalPalette is Tableau 10.
+var categoricalPalette = []color.NRGBA{
+	{0x4e, 0x79, 0xa7, 0xff},
+	{0xf2, 0x8e, 0x2b, 0xff},
+	{0xe1, 0x57, 0x59, 0xff},
+	{0x76, 0xb7, 0xb2, 0xff},
+	{0x59, 0xa1, 0x4f, 0xff},
+	{0xed, 0xc9, 0x48, 0xff},
+	{0xb0, 0x7a, 0xa1, 0xff},
+	{0xff, 0x9d, 0xa7, 0xff},
+	{0x9c, 0x75, 0x5f, 0xff},
+	{0xba, 0xb0, 0xac, 0xff},
+}
+
+// Categorical hashes each value onto a palette so ids (e.g. authors or
+// file hashes) get stable, distinct colors. The range is ignored.
+func Categorical(value int32, _ Range) color.NRGBA {
+	h := uint32(value) * 2654435761
+	return categoricalPalette[(h>>16)%uint32(len(categoricalPalette))]
+}
+
+// Draw paints a square tile of values into img with its top-left corner
+// at origin. Zero pixels are left transparent.
+func Draw(img *image.NRGBA, origin image.Point, tile []int32, size int, cm Colormap, r Range) {
+	for y := 0; y < size; y++ {
+		for x := 0; x < size; x++ {
+			value := tile[y*size+x]
+			if value == 0 {
+				continue
+			}
+			img.SetNRGBA(origin.X+x, origin.Y+y, cm(value, r))
+		}
+	}
+}
+
+// Render paints a square tile of values into a new image.
+func Render(tile []int32, size int, cm Colormap, r Range) *image.NRGBA {
+	img := image.NewNRGBA(image.Rect(0, 0, size, size))
+	Draw(img, image.Point{}, tile, size, cm, r)
+	return img
+}
@@ -0,0 +1,83 @@
+package colormap
+
+import (
+	"image/color"
+	"testing"
+)
+
+func TestNormalize(t *testing.T) {
+	tests := []struct {
+		name     string
+		r        Range
+		value    int32
+		expected float64
+	}{
+		{"min", Range{Min: 10, Max: 20}, 10, 0},
+		{"max", Range{Min: 10, Max: 20}, 20, 1},
+		{"middle", Range{Min: 10, Max: 20}, 15, 0.5},
+		{"below", Range{Min: 10, Max: 20}, -100, 0},
+		{"above", Range{Min: 10, Max: 20}, 100, 1},
+		{"empty range", Range{Min: 5, Max: 5}, 5, 0.5},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := test.r.Normalize(test.value); actual != test.expected {
+				t.Errorf("Normalize(%d) = %v, expected %v", test.value, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestAutoRange(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []int32
+		expected Range
+	}{
+		{"ignores zero", []int32{0, 3, 7, 0}, Range{Min: 3, Max: 7}},
+		{"negative", []int32{-4, 2}, Range{Min: -4, Max: 2}},
+		{"all zero", []int32{0, 0}, Range{Min: 0, Max: 1}},
+		{"empty", []int32{}, Range{Min: 0, Max: 1}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			if actual := AutoRange(test.values); actual != test.expected {
+				t.Errorf("AutoRange(%v) = %v, expected %v", test.values, actual, test.expected)
+			}
+		})
+	}
+}
+
+func TestColormapEndpoints(t *testing.T) {
+	r := Range{Min: 0, Max: 100}
+
+	if c := Viridis(0, r); c != viridisStops[0] {
+		t.Errorf("Expected viridis min %v got %v", viridisStops[0], c)
+	}
+	if c := Viridis(100, r); c != viridisStops[len(viridisStops)-1] {
+		t.Errorf("Expected viridis max %v got %v", viridisStops[len(viridisStops)-1], c)
+	}
+	if c := Diverging(50, r); c != divergingStops[1] {
+		t.Errorf("Expected diverging middle %v got %v", divergingStops[1], c)
+	}
+	if Categorical(42, r) != Categorical(42, Range{}) {
+		t.Errorf("Expected categorical colors to ignore the range")
+	}
+}
+
+func TestRender(t *testing.T) {
+	tile := []int32{0, 1, 2, 3}
+	img := Render(tile, 2, Viridis, Range{Min: 1, Max: 3})
+
+	if c := img.NRGBAAt(0, 0); c != (color.NRGBA{}) {
+		t.Errorf("Expected empty pixel to be transparent got %v", c)
+	}
+	if c := img.NRGBAAt(1, 0); c != viridisStops[0] {
+		t.Errorf("Expected %v got %v", viridisStops[0], c)
+	}
+	if c := img.NRGBAAt(1, 1); c != viridisStops[len(viridisStops)-1] {
+		t.Errorf("Expected %v got %v", viridisStops[len(viridisStops)-1], c)
+	}
+}
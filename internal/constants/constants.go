@@ -1,3 +1,8 @@
 package constants
 
 const TileSize = 64
+
+// MaxLod is the highest level of detail a tile can be requested at. A
+// tile at MaxLod is TileSize<<MaxLod = 2^62 pixels on a side, far more
+// than any repo needs, which still fits in an int64.
+const MaxLod = 56
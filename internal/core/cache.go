@@ -2,6 +2,7 @@ package core
 
 import (
 	"github.com/chromy/mylar/internal/cache"
+	"github.com/vmihailenco/msgpack/v5"
 )
 
 func InitCache(c cache.Cache) {
@@ -11,3 +12,34 @@ func InitCache(c cache.Cache) {
 func GetCache() cache.Cache {
 	return theCache
 }
+
+// GetOrCompute returns the value cached under key, otherwise it calls
+// compute and caches the result. Values are stored msgpack encoded.
+func GetOrCompute[T any](key string, compute func() (T, error)) (T, error) {
+	c := GetCache()
+
+	if cached, err := c.Get(key); err == nil {
+		var result T
+		if err := msgpack.Unmarshal(cached, &result); err != nil {
+			var zero T
+			return zero, err
+		}
+		return result, nil
+	}
+
+	result, err := compute()
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	serialized, err := msgpack.Marshal(result)
+	if err != nil {
+		var zero T
+		return zero, err
+	}
+
+	c.Add(key, serialized)
+
+	return result, nil
+}
@@ -0,0 +1,49 @@
+package core
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestGetOrComputeCachesResults(t *testing.T) {
+	callCount := 0
+	compute := func() ([]string, error) {
+		callCount += 1
+		return []string{"a", "b"}, nil
+	}
+
+	key := GenerateCacheKey("TestGetOrComputeCachesResults")
+
+	a, aErr := GetOrCompute(key, compute)
+	b, bErr := GetOrCompute(key, compute)
+
+	if aErr != nil || bErr != nil {
+		t.Fatalf("Expected computations to succeed: %v %v", aErr, bErr)
+	}
+	if len(a) != 2 || len(b) != 2 || b[1] != "b" {
+		t.Errorf("Expected [a b] got %v and %v", a, b)
+	}
+	if callCount != 1 {
+		t.Errorf("Expected single call, got %d", callCount)
+	}
+}
+
+func TestGetOrComputeDoesNotCacheErrors(t *testing.T) {
+	callCount := 0
+	compute := func() (int, error) {
+		callCount += 1
+		return 0, fmt.Errorf("failed")
+	}
+
+	key := GenerateCacheKey("TestGetOrComputeDoesNotCacheErrors")
+
+	GetOrCompute(key, compute)
+	_, err := GetOrCompute(key, compute)
+
+	if err == nil {
+		t.Error("Expected error")
+	}
+	if callCount != 2 {
+		t.Errorf("Expected two calls, got %d", callCount)
+	}
+}
@@ -21,8 +21,12 @@ var storageOnce sync.Once
 var version string
 var versionOnce sync.Once
 
+// devVersion is the version of builds without VCS information. Their
+// code can change while the version stays the same.
+const devVersion = "dev"
+
 func initVersion() {
-	version = "dev"
+	version = devVersion
 
 	if info, ok := debug.ReadBuildInfo(); ok {
 		for _, setting := range info.Settings {
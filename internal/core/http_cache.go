@@ -0,0 +1,72 @@
+package core
+
+import (
+	"net/http"
+	"strings"
+)
+
+// Responses addressed by a full commit or object hash never change for
+// a given server version so browsers and CDNs may keep them forever.
+// Responses addressed by a committish (e.g. a branch name) only get a
+// short max-age since the ref can move. Dev builds treat everything as
+// short lived since their version doesn't change with the code.
+const (
+	immutableCacheControl  = "public, max-age=31536000, immutable"
+	shortLivedCacheControl = "public, max-age=60"
+)
+
+// ImmutableETag returns a strong ETag for a response entirely
+// determined by parts. The server version is mixed in (via
+// GenerateCacheKey) so a new deploy invalidates old ETags.
+func ImmutableETag(parts ...string) string {
+	return `"` + GenerateCacheKey(parts...) + `"`
+}
+
+// etagMatches reports if an If-None-Match header value matches etag.
+func etagMatches(ifNoneMatch string, etag string) bool {
+	for _, candidate := range strings.Split(ifNoneMatch, ",") {
+		candidate = strings.TrimSpace(candidate)
+		if candidate == "*" {
+			return true
+		}
+		if strings.TrimPrefix(candidate, "W/") == etag {
+			return true
+		}
+	}
+	return false
+}
+
+// CheckNotModified writes a 304 and returns true if the client already
+// has the response identified by etag. Call it before doing any work
+// to produce the response.
+func CheckNotModified(w http.ResponseWriter, r *http.Request, etag string) bool {
+	if GetVersion() == devVersion {
+		return false
+	}
+	ifNoneMatch := r.Header.Get("If-None-Match")
+	if ifNoneMatch == "" || !etagMatches(ifNoneMatch, etag) {
+		return false
+	}
+
+	SetImmutableHeaders(w, etag)
+	w.WriteHeader(http.StatusNotModified)
+	return true
+}
+
+// SetImmutableHeaders marks a successful response as cacheable forever.
+// Only call it once the response is known to be successful so errors
+// are never cached.
+func SetImmutableHeaders(w http.ResponseWriter, etag string) {
+	if GetVersion() == devVersion {
+		SetShortLivedHeaders(w)
+		return
+	}
+	w.Header().Set("ETag", etag)
+	w.Header().Set("Cache-Control", immutableCacheControl)
+}
+
+// SetShortLivedHeaders marks a successful committish addressed response
+// as cacheable for a short time.
+func SetShortLivedHeaders(w http.ResponseWriter) {
+	w.Header().Set("Cache-Control", shortLivedCacheControl)
+}
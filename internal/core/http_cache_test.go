@@ -0,0 +1,94 @@
+package core
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestEtagMatches(t *testing.T) {
+	etag := `"abc"`
+	tests := []struct {
+		ifNoneMatch string
+		expected    bool
+	}{
+		{`"abc"`, true},
+		{`W/"abc"`, true},
+		{`"xyz", "abc"`, true},
+		{`*`, true},
+		{`"xyz"`, false},
+		{`abc`, false},
+	}
+
+	for _, test := range tests {
+		if actual := etagMatches(test.ifNoneMatch, etag); actual != test.expected {
+			t.Errorf("etagMatches(%q, %q) = %v, expected %v", test.ifNoneMatch, etag, actual, test.expected)
+		}
+	}
+}
+
+func TestImmutableETagDependsOnParts(t *testing.T) {
+	a := ImmutableETag("tile", "1")
+	b := ImmutableETag("tile", "2")
+	if a == b {
+		t.Errorf("Expected different ETags got %s for both", a)
+	}
+	if a != ImmutableETag("tile", "1") {
+		t.Errorf("Expected ETag to be stable")
+	}
+}
+
+// withVersion pretends the server is at v for the rest of the test.
+func withVersion(t *testing.T, v string) {
+	GetVersion()
+	old := version
+	version = v
+	t.Cleanup(func() { version = old })
+}
+
+func TestCheckNotModified(t *testing.T) {
+	withVersion(t, "abc123")
+	etag := ImmutableETag("TestCheckNotModified")
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	w := httptest.NewRecorder()
+	if CheckNotModified(w, r, etag) {
+		t.Errorf("Expected request without If-None-Match to be modified")
+	}
+
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	if !CheckNotModified(w, r, etag) {
+		t.Fatalf("Expected request with matching If-None-Match to be not modified")
+	}
+	if w.Code != http.StatusNotModified {
+		t.Errorf("Expected status 304 got %d", w.Code)
+	}
+	if w.Header().Get("ETag") != etag {
+		t.Errorf("Expected ETag %s got %s", etag, w.Header().Get("ETag"))
+	}
+	if w.Header().Get("Cache-Control") != immutableCacheControl {
+		t.Errorf("Expected immutable Cache-Control got %s", w.Header().Get("Cache-Control"))
+	}
+}
+
+func TestDevVersionIsShortLived(t *testing.T) {
+	withVersion(t, devVersion)
+	etag := ImmutableETag("TestDevVersionIsShortLived")
+
+	w := httptest.NewRecorder()
+	SetImmutableHeaders(w, etag)
+	if w.Header().Get("Cache-Control") != shortLivedCacheControl {
+		t.Errorf("Expected short lived Cache-Control got %s", w.Header().Get("Cache-Control"))
+	}
+	if w.Header().Get("ETag") != "" {
+		t.Errorf("Expected no ETag got %s", w.Header().Get("ETag"))
+	}
+
+	r := httptest.NewRequest(http.MethodGet, "/", nil)
+	r.Header.Set("If-None-Match", etag)
+	w = httptest.NewRecorder()
+	if CheckNotModified(w, r, etag) {
+		t.Errorf("Expected dev builds to never answer not modified")
+	}
+}
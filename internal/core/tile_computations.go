@@ -3,31 +3,204 @@ package core
 import (
 	"context"
 	"fmt"
+	"github.com/chromy/mylar/internal/constants"
 	"github.com/go-git/go-git/v5/plumbing"
+	"net/url"
+	"strconv"
 )
 
-type TileFunc func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error)
+// TileParams are extra arguments to a tile computation (e.g. a search
+// query). Values are validated and normalised against the parameters
+// the computation declares by ParseTileParams.
+type TileParams map[string]string
+
+// Key returns a canonical encoding of the params suitable for use as
+// part of a cache key.
+func (p TileParams) Key() string {
+	values := url.Values{}
+	for k, v := range p {
+		values.Set(k, v)
+	}
+	return values.Encode()
+}
+
+// String returns the named param or "" if it is not set.
+func (p TileParams) String(name string) string {
+	return p[name]
+}
+
+// Int returns the named param or 0 if it is not set or not an int.
+func (p TileParams) Int(name string) int64 {
+	n, _ := strconv.ParseInt(p[name], 10, 64)
+	return n
+}
+
+// Bool returns the named param or false if it is not set or not a bool.
+func (p TileParams) Bool(name string) bool {
+	b, _ := strconv.ParseBool(p[name])
+	return b
+}
+
+type TileParamType string
+
+const (
+	TileParamString TileParamType = "string"
+	TileParamInt    TileParamType = "int"
+	TileParamBool   TileParamType = "bool"
+)
+
+// TileParam declares a parameter accepted by a tile computation.
+type TileParam struct {
+	Name     string        `json:"name"`
+	Type     TileParamType `json:"type"`
+	Default  string        `json:"default,omitempty"`
+	Required bool          `json:"required,omitempty"`
+}
+
+func (param TileParam) normalize(raw string) (string, error) {
+	switch param.Type {
+	case TileParamString:
+		return raw, nil
+	case TileParamInt:
+		n, err := strconv.ParseInt(raw, 10, 64)
+		if err != nil {
+			return "", fmt.Errorf("param %s must be an int, got '%s'", param.Name, raw)
+		}
+		return strconv.FormatInt(n, 10), nil
+	case TileParamBool:
+		b, err := strconv.ParseBool(raw)
+		if err != nil {
+			return "", fmt.Errorf("param %s must be a bool, got '%s'", param.Name, raw)
+		}
+		return strconv.FormatBool(b), nil
+	default:
+		return "", fmt.Errorf("param %s has unknown type %s", param.Name, param.Type)
+	}
+}
+
+// ParseTileParams picks the declared params out of values, checking
+// their types and filling in defaults. Undeclared values are dropped
+// so they can't fragment the cache.
+func ParseTileParams(declared []TileParam, values url.Values) (TileParams, error) {
+	params := TileParams{}
+	for _, param := range declared {
+		raw := values.Get(param.Name)
+		if raw == "" {
+			if param.Required {
+				return nil, fmt.Errorf("param %s must be set", param.Name)
+			}
+			raw = param.Default
+		}
+		if raw == "" {
+			continue
+		}
+
+		value, err := param.normalize(raw)
+		if err != nil {
+			return nil, err
+		}
+		params[param.Name] = value
+	}
+	return params, nil
+}
+
+// filterTileParams drops any params which are not declared.
+func filterTileParams(declared []TileParam, params TileParams) TileParams {
+	filtered := TileParams{}
+	for _, param := range declared {
+		if value, found := params[param.Name]; found {
+			filtered[param.Name] = value
+		}
+	}
+	return filtered
+}
+
+type TileFunc func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params TileParams) ([]int32, error)
+
+// TileChannel names one of the values a multi-channel computation
+// produces for each pixel. Agg is the aggregation used for macro-tiles
+// when a request doesn't give one. Categorical channels hold ids or
+// states rather than quantities so aggregations which blend values
+// (e.g. mean) are refused.
+type TileChannel struct {
+	Name        string `json:"name"`
+	Agg         string `json:"agg,omitempty"`
+	Categorical bool   `json:"categorical,omitempty"`
+}
 
 type TileComputation struct {
-	Id      string
-	Execute TileFunc
+	Id     string
+	Params []TileParam
+	// Channels is empty for computations with a single value per pixel.
+	// Otherwise tiles hold one TileSize*TileSize plane per channel, in
+	// order.
+	Channels []TileChannel
+	// Agg and Categorical describe the single value of computations
+	// without Channels in the same way as TileChannel.
+	Agg         string
+	Categorical bool
+	Execute     TileFunc
+}
+
+// Channel describes channel i. Computations with a single value per
+// pixel describe it with their own Agg and Categorical.
+func (c TileComputation) Channel(i int) TileChannel {
+	if len(c.Channels) == 0 {
+		return TileChannel{Name: c.Id, Agg: c.Agg, Categorical: c.Categorical}
+	}
+	return c.Channels[i]
+}
+
+// TileOption configures a computation registered with
+// RegisterTileComputation.
+type TileOption func(computation *TileComputation)
+
+// WithTileParams declares the params a computation accepts.
+func WithTileParams(params ...TileParam) TileOption {
+	return func(computation *TileComputation) {
+		computation.Params = append(computation.Params, params...)
+	}
+}
+
+// WithDefaultAgg sets the aggregation used for macro-tiles when a
+// request doesn't give one.
+func WithDefaultAgg(agg string) TileOption {
+	return func(computation *TileComputation) {
+		computation.Agg = agg
+	}
+}
+
+// WithCategorical marks the values as categories (see TileChannel).
+func WithCategorical() TileOption {
+	return func(computation *TileComputation) {
+		computation.Categorical = true
+	}
+}
+
+// ChannelCount is the number of planes in each tile.
+func (c TileComputation) ChannelCount() int {
+	return max(len(c.Channels), 1)
 }
 
 var tileComputations map[string]TileComputation = make(map[string]TileComputation)
 
-func wrapTileFuncWithCaching(id string, execute TileFunc) TileFunc {
-	return func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error) {
-		cacheKey := GenerateCacheKey(id, commit.String(), fmt.Sprintf("%d", lod), fmt.Sprintf("%d", x), fmt.Sprintf("%d", y))
+func wrapTileFuncWithCaching(id string, declared []TileParam, channels int, execute TileFunc) TileFunc {
+	return func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params TileParams) ([]int32, error) {
+		params = filterTileParams(declared, params)
+		cacheKey := GenerateCacheKey(id, commit.String(), fmt.Sprintf("%d", lod), fmt.Sprintf("%d", x), fmt.Sprintf("%d", y), params.Key())
 
 		if cached, err := theCache.Get(cacheKey); err == nil {
 			tile := BytesToInt32Slice(cached)
 			return tile, nil
 		}
 
-		result, err := execute(ctx, repoId, commit, lod, x, y)
+		result, err := execute(ctx, repoId, commit, lod, x, y, params)
 		if err != nil {
 			return nil, err
 		}
+		if channels > 1 && len(result) != channels*constants.TileSize*constants.TileSize {
+			return nil, fmt.Errorf("tile computation %s returned %d values, expected %d channels", id, len(result), channels)
+		}
 
 		tileData := Int32SliceToBytes(result)
 		theCache.Add(cacheKey, tileData)
@@ -36,22 +209,40 @@ func wrapTileFuncWithCaching(id string, execute TileFunc) TileFunc {
 	}
 }
 
-func RegisterTileComputation(id string, execute TileFunc) TileFunc {
+// RegisterTileComputation registers a tile computation with a single
+// value per pixel. Any params the computation accepts must be declared
+// with WithTileParams.
+func RegisterTileComputation(id string, execute TileFunc, options ...TileOption) TileFunc {
+	computation := TileComputation{Id: id}
+	for _, option := range options {
+		option(&computation)
+	}
+	return registerTileComputation(computation, execute)
+}
+
+// RegisterMultiChannelTileComputation registers a tile computation
+// which produces a value per channel for each pixel. execute must
+// return the planes for each channel one after another.
+func RegisterMultiChannelTileComputation(id string, channels []TileChannel, execute TileFunc, params ...TileParam) TileFunc {
+	if len(channels) == 0 {
+		panic(fmt.Sprintf("tile computation %s must have at least one channel", id))
+	}
+	return registerTileComputation(TileComputation{Id: id, Params: params, Channels: channels}, execute)
+}
+
+func registerTileComputation(computation TileComputation, execute TileFunc) TileFunc {
 	mu.Lock()
 	defer mu.Unlock()
 
+	id := computation.Id
 	if _, found := tileComputations[id]; found {
 		panic(fmt.Sprintf("tile computation already registered %s", id))
 	}
 
-	wrapped := wrapTileFuncWithCaching(id, execute)
-
-	tileComputations[id] = TileComputation{
-		Id:      id,
-		Execute: wrapped,
-	}
+	computation.Execute = wrapTileFuncWithCaching(id, computation.Params, computation.ChannelCount(), execute)
+	tileComputations[id] = computation
 
-	return wrapped
+	return computation.Execute
 }
 
 func GetTileComputation(id string) (TileComputation, bool) {
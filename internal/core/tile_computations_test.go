@@ -0,0 +1,149 @@
+package core
+
+import (
+	"context"
+	"github.com/chromy/mylar/internal/constants"
+	"github.com/go-git/go-git/v5/plumbing"
+	"net/url"
+	"testing"
+)
+
+func TestTileParamsKey(t *testing.T) {
+	tests := []struct {
+		name     string
+		params   TileParams
+		expected string
+	}{
+		{"Nil params", nil, ""},
+		{"Empty params", TileParams{}, ""},
+		{"Single param", TileParams{"q": "TODO"}, "q=TODO"},
+		{"Sorted by name", TileParams{"regex": "1", "q": "a b"}, "q=a+b&regex=1"},
+		{"Escapes separators", TileParams{"q": "a&b=c"}, "q=a%26b%3Dc"},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := tt.params.Key(); result != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseTileParams(t *testing.T) {
+	declared := []TileParam{
+		{Name: "q", Type: TileParamString, Required: true},
+		{Name: "regex", Type: TileParamBool, Default: "false"},
+		{Name: "threshold", Type: TileParamInt},
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected string
+		wantErr  bool
+	}{
+		{"Defaults applied", "q=TODO", "q=TODO&regex=false", false},
+		{"Bool normalised", "q=TODO&regex=1", "q=TODO&regex=true", false},
+		{"Int normalised", "q=TODO&threshold=007", "q=TODO&regex=false&threshold=7", false},
+		{"Undeclared dropped", "q=TODO&agg=sum&foo=bar", "q=TODO&regex=false", false},
+		{"Missing required", "regex=1", "", true},
+		{"Bad bool", "q=TODO&regex=maybe", "", true},
+		{"Bad int", "q=TODO&threshold=ten", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			values, _ := url.ParseQuery(tt.query)
+			params, err := ParseTileParams(declared, values)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %v", params)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("ParseTileParams failed: %v", err)
+			}
+			if params.Key() != tt.expected {
+				t.Errorf("Expected %q, got %q", tt.expected, params.Key())
+			}
+		})
+	}
+}
+
+func TestTileParamsGetters(t *testing.T) {
+	params := TileParams{"q": "TODO", "regex": "true", "threshold": "7"}
+
+	if params.String("q") != "TODO" {
+		t.Errorf("Expected q to be TODO, got %s", params.String("q"))
+	}
+	if !params.Bool("regex") {
+		t.Error("Expected regex to be true")
+	}
+	if params.Int("threshold") != 7 {
+		t.Errorf("Expected threshold to be 7, got %d", params.Int("threshold"))
+	}
+	if params.Bool("missing") || params.Int("missing") != 0 || params.String("missing") != "" {
+		t.Error("Expected zero values for missing params")
+	}
+}
+
+func TestMultiChannelTileComputation(t *testing.T) {
+	channels := []TileChannel{{Name: "a", Agg: "mode"}, {Name: "b"}}
+	f := RegisterMultiChannelTileComputation("TestMultiChannelTileComputation", channels, func(ctx context.Context, _ string, _ plumbing.Hash, lod int64, x int64, y int64, _ TileParams) ([]int32, error) {
+		// Return the wrong size for tile (1, 0)
+		return make([]int32, int(x+2)*constants.TileSize*constants.TileSize), nil
+	})
+
+	c, found := GetTileComputation("TestMultiChannelTileComputation")
+	if !found {
+		t.Fatal("Expected tile computation to be registered")
+	}
+	if c.ChannelCount() != 2 || c.Channels[0].Name != "a" {
+		t.Errorf("Expected channels a and b, got %v", c.Channels)
+	}
+
+	commit := plumbing.NewHash("efc4fcc2e78479e60133c9dcb3460c45a1c0efa9")
+	tile, err := f(context.Background(), "", commit, 0, 0, 0, nil)
+	if err != nil {
+		t.Fatalf("Expected tile to succeed: %v", err)
+	}
+	if len(tile) != 2*constants.TileSize*constants.TileSize {
+		t.Errorf("Expected two planes, got %d values", len(tile))
+	}
+
+	if _, err := f(context.Background(), "", commit, 0, 1, 0, nil); err == nil {
+		t.Error("Expected an error for a tile with the wrong number of channels")
+	}
+}
+
+func TestSingleChannelTileComputation(t *testing.T) {
+	RegisterTileComputation("TestSingleChannelTileComputation", func(ctx context.Context, _ string, _ plumbing.Hash, lod int64, x int64, y int64, _ TileParams) ([]int32, error) {
+		return nil, nil
+	})
+
+	c, _ := GetTileComputation("TestSingleChannelTileComputation")
+	if c.ChannelCount() != 1 || len(c.Channels) != 0 {
+		t.Errorf("Expected a single unnamed channel, got %v", c.Channels)
+	}
+}
+
+func TestTileComputationOptions(t *testing.T) {
+	params := []TileParam{{Name: "base", Type: TileParamString, Required: true}}
+	RegisterTileComputation("TestTileComputationOptions", func(ctx context.Context, _ string, _ plumbing.Hash, lod int64, x int64, y int64, _ TileParams) ([]int32, error) {
+		return nil, nil
+	}, WithTileParams(params...), WithDefaultAgg("max"), WithCategorical())
+
+	c, _ := GetTileComputation("TestTileComputationOptions")
+	if len(c.Params) != 1 || c.Params[0].Name != "base" {
+		t.Errorf("Expected the base param, got %v", c.Params)
+	}
+	if c.ChannelCount() != 1 || len(c.Channels) != 0 {
+		t.Errorf("Expected a single unnamed channel, got %v", c.Channels)
+	}
+	expected := TileChannel{Name: "TestTileComputationOptions", Agg: "max", Categorical: true}
+	if channel := c.Channel(0); channel != expected {
+		t.Errorf("Expected %v, got %v", expected, channel)
+	}
+}
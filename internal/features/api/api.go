@@ -1,18 +1,27 @@
 package api
 
 import (
+	"bytes"
+	"compress/gzip"
 	"context"
+	"encoding/binary"
 	"encoding/json"
 	"fmt"
 	"github.com/chromy/mylar/internal/constants"
 	"github.com/chromy/mylar/internal/core"
-	//	"github.com/chromy/mylar/internal/features/index"
+	"github.com/chromy/mylar/internal/features/index"
 	"github.com/chromy/mylar/internal/schemas"
+	"github.com/chromy/mylar/internal/utils"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/julienschmidt/httprouter"
 	"golang.org/x/sync/errgroup"
+	"math"
 	"net/http"
+	"slices"
+	"sort"
 	"strconv"
+	"strings"
+	"sync"
 )
 
 type TileMetadata struct {
@@ -21,6 +30,159 @@ type TileMetadata struct {
 	Lod int64 `json:"lod"`
 }
 
+// Tiles are served either as JSON (the metadata followed by the tile as
+// an array) or, if the client sends Accept: application/octet-stream, as
+// a binaryTileHeader followed by the raw little-endian int32 pixels.
+// Multi-channel tiles hold each channel's TileSize*TileSize plane one
+// after another so the number of channels is the length over the size
+// of a plane.
+const binaryTileContentType = "application/octet-stream"
+
+var binaryTileMagic = [4]byte{'M', 'Y', 'L', 'T'}
+
+const binaryTileVersion = 1
+
+// binaryTileHeader is 24 bytes so the payload stays 4-byte aligned.
+type binaryTileHeader struct {
+	Magic    [4]byte
+	Version  uint16
+	TileSize uint16
+	Lod      int32
+	X        int32
+	Y        int32
+	Length   uint32
+}
+
+func acceptsBinaryTile(r *http.Request) bool {
+	for _, accept := range r.Header.Values("Accept") {
+		for _, mediaRange := range strings.Split(accept, ",") {
+			mediaType, _, _ := strings.Cut(mediaRange, ";")
+			if strings.TrimSpace(mediaType) == binaryTileContentType {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func encodeBinaryTile(metadata TileMetadata, tile []int32) ([]byte, error) {
+	header := binaryTileHeader{
+		Magic:    binaryTileMagic,
+		Version:  binaryTileVersion,
+		TileSize: constants.TileSize,
+		Lod:      int32(metadata.Lod),
+		X:        int32(metadata.X),
+		Y:        int32(metadata.Y),
+		Length:   uint32(len(tile)),
+	}
+
+	var buf bytes.Buffer
+	buf.Grow(binary.Size(header) + len(tile)*4)
+	if err := binary.Write(&buf, binary.LittleEndian, header); err != nil {
+		return nil, err
+	}
+	buf.Write(core.Int32SliceToBytes(tile))
+	return buf.Bytes(), nil
+}
+
+func writeBinaryTile(w http.ResponseWriter, metadata TileMetadata, tile []int32) error {
+	encoded, err := encodeBinaryTile(metadata, tile)
+	if err != nil {
+		return err
+	}
+
+	w.Header().Set("Content-Type", binaryTileContentType)
+	_, err = w.Write(encoded)
+	return err
+}
+
+var gzipWriterPool = sync.Pool{
+	New: func() interface{} {
+		w, err := gzip.NewWriterLevel(nil, gzip.BestSpeed)
+		if err != nil {
+			panic(err)
+		}
+		return w
+	},
+}
+
+// parseCoding splits an Accept-Encoding entry such as "gzip;q=0.5" into
+// its lower case name and q-value. The q-value defaults to 1 and an
+// unparsable one counts as a refusal.
+func parseCoding(coding string) (string, float64) {
+	name, params, _ := strings.Cut(coding, ";")
+	weight := 1.0
+	for _, param := range strings.Split(params, ";") {
+		key, value, _ := strings.Cut(param, "=")
+		if !strings.EqualFold(strings.TrimSpace(key), "q") {
+			continue
+		}
+		q, err := strconv.ParseFloat(strings.TrimSpace(value), 64)
+		if err != nil {
+			q = 0
+		}
+		weight = q
+	}
+	return strings.ToLower(strings.TrimSpace(name)), weight
+}
+
+// acceptsGzip reports if gzip has a non-zero q-value in the request's
+// Accept-Encoding. An explicit gzip entry takes precedence over "*".
+func acceptsGzip(r *http.Request) bool {
+	gzipWeight, wildcardWeight := -1.0, -1.0
+	for _, accept := range r.Header.Values("Accept-Encoding") {
+		for _, coding := range strings.Split(accept, ",") {
+			name, weight := parseCoding(coding)
+			switch name {
+			case "gzip", "x-gzip":
+				gzipWeight = max(gzipWeight, weight)
+			case "*":
+				wildcardWeight = max(wildcardWeight, weight)
+			}
+		}
+	}
+	if gzipWeight >= 0 {
+		return gzipWeight > 0
+	}
+	return wildcardWeight > 0
+}
+
+// gzipResponseWriter compresses everything written through it.
+type gzipResponseWriter struct {
+	http.ResponseWriter
+	gz *gzip.Writer
+}
+
+func (w *gzipResponseWriter) Write(b []byte) (int, error) {
+	return w.gz.Write(b)
+}
+
+// Flush sends everything written so far to the client so streamed
+// responses aren't held back by the compressor.
+func (w *gzipResponseWriter) Flush() {
+	w.gz.Flush()
+	if flusher, ok := w.ResponseWriter.(http.Flusher); ok {
+		flusher.Flush()
+	}
+}
+
+// compressResponse wraps w with gzip if the client accepts it. The
+// returned function must be called once the response is written.
+func compressResponse(w http.ResponseWriter, r *http.Request) (http.ResponseWriter, func()) {
+	if !acceptsGzip(r) {
+		return w, func() {}
+	}
+
+	w.Header().Set("Content-Encoding", "gzip")
+	gz := gzipWriterPool.Get().(*gzip.Writer)
+	gz.Reset(w)
+
+	return &gzipResponseWriter{ResponseWriter: w, gz: gz}, func() {
+		gz.Close()
+		gzipWriterPool.Put(gz)
+	}
+}
+
 type AggregationType int
 
 const (
@@ -28,36 +190,201 @@ const (
 	AggregationMode
 	AggregationMax
 	AggregationMin
+	AggregationSum
+	AggregationCount
+	AggregationDistinct
+	AggregationP50
+	AggregationP90
 )
 
+// AggregationNonZero can be combined with any aggregation to leave out
+// zero values. Use it for computations where zero means "no data" so
+// missing values don't dilute the mean, min or mode. It is written as
+// a ":nonzero" suffix e.g. ?agg=mean:nonzero.
+const AggregationNonZero AggregationType = 1 << 8
+
+var aggregationNames = []string{"mean", "mode", "max", "min", "sum", "count", "distinct", "p50", "p90"}
+
+// base strips any modifiers.
+func (agg AggregationType) base() AggregationType {
+	return agg &^ AggregationNonZero
+}
+
+// blends reports whether agg can produce values other than its inputs
+// which is meaningless for categorical channels.
+func (agg AggregationType) blends() bool {
+	switch agg.base() {
+	case AggregationMean, AggregationSum, AggregationP50, AggregationP90:
+		return true
+	default:
+		return false
+	}
+}
+
 func parseAggregationType(s string) (AggregationType, error) {
-	switch s {
-	case "mean":
-		return AggregationMean, nil
-	case "mode":
-		return AggregationMode, nil
-	case "max":
-		return AggregationMax, nil
-	case "min":
-		return AggregationMin, nil
+	name, modifier, hasModifier := strings.Cut(s, ":")
+
+	var flags AggregationType
+	if hasModifier {
+		if modifier != "nonzero" {
+			return AggregationMean, fmt.Errorf("invalid aggregation modifier '%s'. Valid options: nonzero", modifier)
+		}
+		flags |= AggregationNonZero
+	}
+
+	for i, candidate := range aggregationNames {
+		if name == candidate {
+			return AggregationType(i) | flags, nil
+		}
+	}
+	return AggregationMean, fmt.Errorf("invalid aggregation type '%s'. Valid options: %s", s, strings.Join(aggregationNames, ", "))
+}
+
+// parseAggregations reads the aggregation for each channel of
+// computation from a comma separated list e.g. ?agg=mode,mean,max. A
+// single aggregation applies to every channel. If s is empty each
+// channel uses its declared aggregation or mean.
+func parseAggregations(s string, computation core.TileComputation) ([]AggregationType, error) {
+	channels := computation.ChannelCount()
+	names := make([]string, channels)
+	switch parts := strings.Split(s, ","); {
+	case s == "":
+		for i := range names {
+			names[i] = "mean"
+			if agg := computation.Channel(i).Agg; agg != "" {
+				names[i] = agg
+			}
+		}
+	case len(parts) == 1:
+		for i := range names {
+			names[i] = s
+		}
+	case len(parts) == channels:
+		copy(names, parts)
+	default:
+		return nil, fmt.Errorf("agg must be a single aggregation or one for each of the %d channels of %s", channels, computation.Id)
+	}
+
+	aggs := make([]AggregationType, channels)
+	for i, name := range names {
+		agg, err := parseAggregationType(strings.TrimSpace(name))
+		if err != nil {
+			return nil, err
+		}
+		if channel := computation.Channel(i); channel.Categorical && agg.blends() {
+			return nil, fmt.Errorf("channel %s of %s is categorical so can't use agg %s", channel.Name, computation.Id, strings.TrimSpace(name))
+		}
+		aggs[i] = agg
+	}
+	return aggs, nil
+}
+
+// aggregationsKey encodes aggs for use in cache keys.
+func aggregationsKey(aggs []AggregationType) string {
+	parts := make([]string, len(aggs))
+	for i, agg := range aggs {
+		parts[i] = strconv.Itoa(int(agg))
+	}
+	return strings.Join(parts, ",")
+}
+
+// parseChannel reads the index of the channel to use from raw (which
+// defaults to the first channel).
+func parseChannel(raw string, computation core.TileComputation) (int, error) {
+	if raw == "" {
+		return 0, nil
+	}
+	channel, err := strconv.Atoi(raw)
+	if err != nil || channel < 0 || channel >= computation.ChannelCount() {
+		return 0, fmt.Errorf("channel must be a number less than %d", computation.ChannelCount())
+	}
+	return channel, nil
+}
+
+// channelPlane returns the values of a single channel of a tile.
+func channelPlane(tile []int32, channel int) []int32 {
+	planeSize := constants.TileSize * constants.TileSize
+	if len(tile) < (channel+1)*planeSize {
+		return make([]int32, planeSize)
+	}
+	return tile[channel*planeSize : (channel+1)*planeSize]
+}
+
+// Macro-tiles are built by aggregating 2x2 blocks of child pixels. At
+// LOD 1 the children are raw values. Above that they are themselves
+// aggregates so some aggregations combine a different child tile:
+// count sums the child counts. Distinct can't be built from child
+// aggregates at all so above LOD 1 it is counted from sets of values
+// carried up the pyramid, capped at maxDistinctValues (see
+// distinctSets). Mean, p50 and p90 above
+// LOD 1 are of the child values rather than the lines so they are only
+// approximate: a percentile of percentiles is not the percentile of
+// the lines.
+
+// childAggregation is the aggregation of the child tiles used to build
+// a macro-tile above LOD 1. Distinct channels ignore their child plane
+// so fetch the cheapest one.
+func childAggregation(agg AggregationType) AggregationType {
+	switch agg.base() {
+	case AggregationDistinct:
+		return AggregationMode | agg&AggregationNonZero
 	default:
-		return AggregationMean, fmt.Errorf("invalid aggregation type '%s'. Valid options: mean, mode, max, min", s)
+		return agg
 	}
 }
 
-func aggregateValues(values []int32, agg AggregationType) int32 {
-	if len(values) == 0 {
-		return 0
+// combineChildValues aggregates the pixels of child tiles which are
+// themselves macro-tiles.
+func combineChildValues(values []int32, agg AggregationType) int32 {
+	switch agg.base() {
+	case AggregationCount:
+		return aggregateValues(values, AggregationSum)
+	default:
+		return aggregateValues(values, agg)
+	}
+}
+
+func clampToInt32(v int64) int32 {
+	if v > math.MaxInt32 {
+		return math.MaxInt32
+	}
+	if v < math.MinInt32 {
+		return math.MinInt32
 	}
+	return int32(v)
+}
+
+// percentile linearly interpolates between the closest ranks.
+func percentile(values []int32, p float64) int32 {
+	sorted := append([]int32(nil), values...)
+	sort.Slice(sorted, func(i, j int) bool {
+		return sorted[i] < sorted[j]
+	})
+
+	rank := p * float64(len(sorted)-1)
+	lower := int(math.Floor(rank))
+	upper := int(math.Ceil(rank))
+	fraction := rank - float64(lower)
+	interpolated := float64(sorted[lower]) + fraction*(float64(sorted[upper])-float64(sorted[lower]))
+	return int32(math.Round(interpolated))
+}
 
-	switch agg {
-	case AggregationMean:
-		var sum int32
+func aggregateValues(values []int32, agg AggregationType) int32 {
+	if agg&AggregationNonZero != 0 {
+		nonZero := make([]int32, 0, len(values))
 		for _, v := range values {
-			sum += v
+			if v != 0 {
+				nonZero = append(nonZero, v)
+			}
 		}
-		return sum / int32(len(values))
+		values = nonZero
+	}
 
+	if len(values) == 0 {
+		return 0
+	}
+
+	switch agg.base() {
 	case AggregationMax:
 		max := values[0]
 		for _, v := range values {
@@ -76,6 +403,38 @@ func aggregateValues(values []int32, agg AggregationType) int32 {
 		}
 		return min
 
+	case AggregationSum:
+		var sum int64
+		for _, v := range values {
+			sum += int64(v)
+		}
+		return clampToInt32(sum)
+
+	case AggregationCount:
+		var count int32
+		for _, v := range values {
+			if v != 0 {
+				count++
+			}
+		}
+		return count
+
+	case AggregationDistinct:
+		// Zero is an empty pixel rather than a value
+		distinct := make(map[int32]bool)
+		for _, v := range values {
+			if v != 0 {
+				distinct[v] = true
+			}
+		}
+		return int32(len(distinct))
+
+	case AggregationP50:
+		return percentile(values, 0.5)
+
+	case AggregationP90:
+		return percentile(values, 0.9)
+
 	case AggregationMode:
 		// Find the most frequent value
 		counts := make(map[int32]int)
@@ -94,12 +453,12 @@ func aggregateValues(values []int32, agg AggregationType) int32 {
 		return mode
 
 	default:
-		// Default to mean if unknown aggregation type
-		var sum int32
+		// Sum in 64 bits so large values don't overflow
+		var sum int64
 		for _, v := range values {
-			sum += v
+			sum += int64(v)
 		}
-		return sum / int32(len(values))
+		return int32(sum / int64(len(values)))
 	}
 }
 
@@ -120,20 +479,147 @@ func ComputeHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 	}
 	hash := plumbing.NewHash(rawHash)
 
+	etag := core.ImmutableETag("api.compute", computationId, repoId, hash.String())
+	if core.CheckNotModified(w, r, etag) {
+		return
+	}
+
 	result, err := computation.Execute(r.Context(), repoId, hash)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	core.SetImmutableHeaders(w, etag)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
 	}
 }
 
-func macroTile(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, agg AggregationType) ([]int32, error) {
+// maxDistinctValues caps the values tracked for each pixel by the
+// distinct aggregation. Pixels over more values than this show
+// maxDistinctValues. The cap bounds the sets carried up the pyramid.
+const maxDistinctValues = 64
+
+// mergeDistinct returns the sorted union of sets keeping only the
+// maxDistinctValues smallest values. A union of capped sets is at least
+// as big as each of them so counts below the cap stay exact.
+func mergeDistinct(sets ...[]int32) []int32 {
+	var merged []int32
+	for _, set := range sets {
+		merged = append(merged, set...)
+	}
+	if len(merged) == 0 {
+		return nil
+	}
+	slices.Sort(merged)
+	merged = slices.Compact(merged)
+	return merged[:min(len(merged), maxDistinctValues)]
+}
+
+// distinctSets returns the distinct non-zero values of channel in the
+// lines under each pixel of a tile. LOD 0 sets come straight from the
+// tile. Above that they merge the 2x2 blocks of the child sets, which
+// are cached, so each level only reads the level below it.
+func distinctSets(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, channel int, aggs []AggregationType, params core.TileParams) ([][]int32, error) {
+	planeSize := constants.TileSize * constants.TileSize
+	sets := make([][]int32, planeSize)
+
+	isBlank, err := index.IsBlankTile(ctx, repoName, commit, lod, x, y)
+	if err != nil {
+		return nil, err
+	}
+	if isBlank {
+		return sets, nil
+	}
+
+	if lod == 0 {
+		tile, err := getTile(ctx, computationId, repoName, commit, 0, x, y, aggs, params)
+		if err != nil {
+			return nil, err
+		}
+		for pixel, v := range channelPlane(tile, channel) {
+			// Zero is an empty pixel rather than a value
+			if v != 0 {
+				sets[pixel] = []int32{v}
+			}
+		}
+		return sets, nil
+	}
+
+	key := core.GenerateCacheKey("distinctSets", computationId, repoName, commit.String(), strconv.FormatInt(lod, 10), strconv.FormatInt(x, 10), strconv.FormatInt(y, 10), strconv.Itoa(channel), params.Key())
+	return core.GetOrCompute(key, func() ([][]int32, error) {
+		childSets := make([][][]int32, 4)
+		g, gctx := errgroup.WithContext(ctx)
+		for i := range childSets {
+			i := i // capture loop variable
+			g.Go(func() error {
+				childX, childY := x*2+int64(i%2), y*2+int64(i/2)
+				child, err := distinctSets(gctx, computationId, repoName, commit, lod-1, childX, childY, channel, aggs, params)
+				if err != nil {
+					return fmt.Errorf("failed to fetch distinct values of tile (%d, %d) at LOD %d: %w", childX, childY, lod-1, err)
+				}
+				childSets[i] = child
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return nil, err
+		}
+
+		for pixel := range sets {
+			// The 2x2 block of the pixel starts at (childX, childY)
+			// within child tile i.
+			parentX, parentY := pixel%constants.TileSize, pixel/constants.TileSize
+			i := parentY*2/constants.TileSize*2 + parentX*2/constants.TileSize
+			childX, childY := parentX*2%constants.TileSize, parentY*2%constants.TileSize
+			child := childSets[i]
+			first := childY*constants.TileSize + childX
+			sets[pixel] = mergeDistinct(child[first], child[first+1], child[first+constants.TileSize], child[first+constants.TileSize+1])
+		}
+		return sets, nil
+	})
+}
+
+// distinctPlanes fills the planes of result whose aggregation in aggs
+// is distinct with the number of distinct non-zero values of the lines
+// under each pixel (see distinctSets). Counting the distinct values of
+// child tiles would only count their modes.
+func distinctPlanes(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, aggs []AggregationType, params core.TileParams, result []int32) error {
+	planeSize := constants.TileSize * constants.TileSize
+	for channel, agg := range aggs {
+		if agg.base() != AggregationDistinct {
+			continue
+		}
+		sets, err := distinctSets(ctx, computationId, repoName, commit, lod, x, y, channel, aggs, params)
+		if err != nil {
+			return err
+		}
+		for pixel, set := range sets {
+			result[channel*planeSize+pixel] = int32(len(set))
+		}
+	}
+	return nil
+}
+
+// childPixel locates a pixel within the 4 child tiles of a macro-tile.
+type childPixel struct {
+	tile  int
+	index int
+}
+
+// macroTile aggregates each channel of the child tiles separately.
+// aggs has the aggregation for each channel.
+func macroTile(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, aggs []AggregationType, params core.TileParams) ([]int32, error) {
 	childLod := lod - 1
+	childAggs := aggs
+	if childLod > 0 {
+		childAggs = make([]AggregationType, len(aggs))
+		for i, agg := range aggs {
+			childAggs[i] = childAggregation(agg)
+		}
+	}
 	xys := [][2]int64{
 		{x * 2, y * 2},     // top-left
 		{x*2 + 1, y * 2},   // top-right
@@ -141,16 +627,33 @@ func macroTile(ctx context.Context, computationId string, repoName string, commi
 		{x*2 + 1, y*2 + 1}, // bottom-right
 	}
 
-	result := make([]int32, constants.TileSize*constants.TileSize)
+	planeSize := constants.TileSize * constants.TileSize
+	result := make([]int32, len(aggs)*planeSize)
+
+	// Above LOD 1 distinct channels are counted from their own sets so
+	// if every channel is distinct the child tiles aren't needed.
+	distinctFromLines := func(agg AggregationType) bool {
+		return childLod > 0 && agg.base() == AggregationDistinct
+	}
+	needChildren := false
+	for _, agg := range aggs {
+		if !distinctFromLines(agg) {
+			needChildren = true
+		}
+	}
+	if !needChildren {
+		err := distinctPlanes(ctx, computationId, repoName, commit, lod, x, y, aggs, params, result)
+		return result, err
+	}
 
 	childTiles := make([][]int32, 4)
-	g, ctx := errgroup.WithContext(ctx)
+	g, gctx := errgroup.WithContext(ctx)
 
 	for i, coords := range xys {
 		i, coords := i, coords // capture loop variables
 		g.Go(func() error {
 			childX, childY := coords[0], coords[1]
-			childTile, childErr := getTile(ctx, computationId, repoName, commit, childLod, childX, childY, agg)
+			childTile, childErr := getTile(gctx, computationId, repoName, commit, childLod, childX, childY, childAggs, params)
 			if childErr != nil {
 				return fmt.Errorf("failed to fetch child tile (%d, %d) at LOD %d: %w", childX, childY, childLod, childErr)
 			}
@@ -163,13 +666,25 @@ func macroTile(ctx context.Context, computationId string, repoName string, commi
 		return result, err
 	}
 
+	// Child pixels past the last line hold no data. They are left out
+	// so they don't drag down the mean, min, mode etc. of partially
+	// covered pixels. Tiles with every line present skip the check.
+	layout, err := index.GetLayoutForCommit(ctx, repoName, commit)
+	if err != nil {
+		return result, err
+	}
+	_, lastLine, _ := utils.TileLineRange(layout, lod, x, y)
+	checkEmpty := lastLine > layout.LineCount
+
+	childPixels := make([]childPixel, 0, 4)
+	childValues := make([]int32, 0, 4)
 	for parentY := 0; parentY < constants.TileSize; parentY++ {
 		for parentX := 0; parentX < constants.TileSize; parentX++ {
 			// Each parent pixel corresponds to a 2x2 block in child tiles
 			childBlockX := parentX * 2
 			childBlockY := parentY * 2
 
-			childValues := make([]int32, 0, 4)
+			childPixels = childPixels[:0]
 
 			// Sample from appropriate child tiles based on position
 			for dy := 0; dy < 2; dy++ {
@@ -199,31 +714,57 @@ func macroTile(ctx context.Context, computationId string, repoName string, commi
 						}
 					}
 
-					if tileIdx < len(childTiles) && childTiles[tileIdx] != nil {
-						childIdx := childY*constants.TileSize + childX
-						if childIdx >= 0 && childIdx < len(childTiles[tileIdx]) {
-							childValues = append(childValues, childTiles[tileIdx][childIdx])
+					if checkEmpty {
+						pixelX := xys[tileIdx][0]*constants.TileSize + int64(childX)
+						pixelY := xys[tileIdx][1]*constants.TileSize + int64(childY)
+						if utils.IsEmptyPixel(layout, childLod, pixelX, pixelY) {
+							continue
 						}
 					}
+
+					childPixels = append(childPixels, childPixel{tile: tileIdx, index: childY*constants.TileSize + childX})
 				}
 			}
 
-			// Apply aggregation function
-			var pixel int32
-			if len(childValues) > 0 {
-				pixel = aggregateValues(childValues, agg)
+			resultIdx := parentY*constants.TileSize + parentX
+			for channel, agg := range aggs {
+				if distinctFromLines(agg) {
+					continue
+				}
+				offset := channel * planeSize
+
+				childValues = childValues[:0]
+				for _, p := range childPixels {
+					if childTiles[p.tile] != nil && offset+p.index < len(childTiles[p.tile]) {
+						childValues = append(childValues, childTiles[p.tile][offset+p.index])
+					}
+				}
+
+				// Apply aggregation function
+				var pixel int32
+				if len(childValues) > 0 {
+					if childLod == 0 {
+						pixel = aggregateValues(childValues, agg)
+					} else {
+						pixel = combineChildValues(childValues, agg)
+					}
+				}
+				result[offset+resultIdx] = pixel
 			}
+		}
+	}
 
-			resultIdx := parentY*constants.TileSize + parentX
-			result[resultIdx] = pixel
+	if childLod > 0 {
+		if err := distinctPlanes(ctx, computationId, repoName, commit, lod, x, y, aggs, params, result); err != nil {
+			return result, err
 		}
 	}
 
 	return result, nil
 }
 
-func cachingMacroTile(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, agg AggregationType) ([]int32, error) {
-	cacheKey := core.GenerateCacheKey("macroTile", computationId, repoName, commit.String(), fmt.Sprintf("%d", lod), fmt.Sprintf("%d", x), fmt.Sprintf("%d", y), fmt.Sprintf("%d", agg))
+func cachingMacroTile(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, aggs []AggregationType, params core.TileParams) ([]int32, error) {
+	cacheKey := core.GenerateCacheKey("macroTile", computationId, repoName, commit.String(), fmt.Sprintf("%d", lod), fmt.Sprintf("%d", x), fmt.Sprintf("%d", y), aggregationsKey(aggs), params.Key())
 
 	cache := core.GetCache()
 	if cached, err := cache.Get(cacheKey); err == nil {
@@ -231,7 +772,7 @@ func cachingMacroTile(ctx context.Context, computationId string, repoName string
 		return result, nil
 	}
 
-	result, err := macroTile(ctx, computationId, repoName, commit, lod, x, y, agg)
+	result, err := macroTile(ctx, computationId, repoName, commit, lod, x, y, aggs, params)
 	if err != nil {
 		return nil, err
 	}
@@ -242,97 +783,194 @@ func cachingMacroTile(ctx context.Context, computationId string, repoName string
 	return result, nil
 }
 
-func getTile(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, agg AggregationType) ([]int32, error) {
-	//isBlank, err := index.IsBlankTile(ctx, repoName, commit, lod, x, y)
-	//if err != nil {
-	//	return []int32{}, err
-	//}
+// getTile returns a tile with a plane for each channel. aggs has the
+// aggregation for each channel (see parseAggregations).
+func getTile(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, lod int64, x int64, y int64, aggs []AggregationType, params core.TileParams) ([]int32, error) {
+	// Skip regions past the last line rather than recursing to LOD 0.
+	isBlank, err := index.IsBlankTile(ctx, repoName, commit, lod, x, y)
+	if err != nil {
+		return []int32{}, err
+	}
 
-	//if isBlank {
-	//	return make([]int32, constants.TileSize*constants.TileSize), nil
-	//}
+	if isBlank {
+		return make([]int32, len(aggs)*constants.TileSize*constants.TileSize), nil
+	}
 
 	if lod == 0 {
 		c, found := core.GetTileComputation(computationId)
 		if !found {
 			return []int32{}, fmt.Errorf("computation %s not found", computationId)
 		}
-		return c.Execute(ctx, repoName, commit, lod, x, y)
+		return c.Execute(ctx, repoName, commit, lod, x, y, params)
 	} else {
-		return cachingMacroTile(ctx, computationId, repoName, commit, lod, x, y, agg)
+		return cachingMacroTile(ctx, computationId, repoName, commit, lod, x, y, aggs, params)
 	}
 }
 
-func TileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
-	repoName := ps.ByName("repoId")
-	if repoName == "" {
-		http.Error(w, "repo must be set", http.StatusBadRequest)
-		return
+// tileRequest is a single tile addressed by the route parameters and
+// query string shared by the tile endpoints.
+type tileRequest struct {
+	computationId string
+	repoName      string
+	commit        string
+	rawLod        string
+	rawX          string
+	rawY          string
+	lod           int64
+	x             int64
+	y             int64
+	aggStr        string
+	aggs          []AggregationType
+	params        core.TileParams
+}
+
+// validateTilePosition rejects tiles which can't exist in any world.
+// Tiles past the edge of a particular world are blank instead.
+func validateTilePosition(lod int64, x int64, y int64) error {
+	if lod < 0 || lod > constants.MaxLod {
+		return fmt.Errorf("lod must be between 0 and %d", constants.MaxLod)
+	}
+	if x < 0 || y < 0 {
+		return fmt.Errorf("x and y must not be negative")
 	}
+	return nil
+}
 
-	commit := ps.ByName("commit")
-	if commit == "" {
-		http.Error(w, "commit must be set", http.StatusBadRequest)
-		return
+// parseTileRequest validates a tile request. On failure it returns the
+// HTTP status to respond with.
+func parseTileRequest(r *http.Request, ps httprouter.Params) (tileRequest, int, error) {
+	req := tileRequest{}
+
+	req.repoName = ps.ByName("repoId")
+	if req.repoName == "" {
+		return req, http.StatusBadRequest, fmt.Errorf("repo must be set")
 	}
 
-	rawX := ps.ByName("x")
-	if rawX == "" {
-		http.Error(w, "x must be set", http.StatusBadRequest)
-		return
+	req.commit = ps.ByName("commit")
+	if req.commit == "" {
+		return req, http.StatusBadRequest, fmt.Errorf("commit must be set")
 	}
-	x, err := strconv.ParseInt(rawX, 10, 64)
+
+	var err error
+
+	req.rawX = ps.ByName("x")
+	if req.rawX == "" {
+		return req, http.StatusBadRequest, fmt.Errorf("x must be set")
+	}
+	req.x, err = strconv.ParseInt(req.rawX, 10, 64)
 	if err != nil {
-		http.Error(w, "x must be number", http.StatusBadRequest)
-		return
+		return req, http.StatusBadRequest, fmt.Errorf("x must be number")
 	}
 
-	rawY := ps.ByName("y")
-	if rawY == "" {
-		http.Error(w, "y must be set", http.StatusBadRequest)
-		return
+	req.rawY = ps.ByName("y")
+	if req.rawY == "" {
+		return req, http.StatusBadRequest, fmt.Errorf("y must be set")
 	}
-	y, err := strconv.ParseInt(rawY, 10, 64)
+	req.y, err = strconv.ParseInt(req.rawY, 10, 64)
 	if err != nil {
-		http.Error(w, "y must be number", http.StatusBadRequest)
-		return
+		return req, http.StatusBadRequest, fmt.Errorf("y must be number")
 	}
 
-	rawLod := ps.ByName("lod")
-	if rawLod == "" {
-		http.Error(w, "lod must be set", http.StatusBadRequest)
-		return
+	req.rawLod = ps.ByName("lod")
+	if req.rawLod == "" {
+		return req, http.StatusBadRequest, fmt.Errorf("lod must be set")
 	}
-	lod, err := strconv.ParseInt(rawLod, 10, 64)
+	req.lod, err = strconv.ParseInt(req.rawLod, 10, 64)
 	if err != nil {
-		http.Error(w, "lod must be number", http.StatusBadRequest)
-		return
+		return req, http.StatusBadRequest, fmt.Errorf("lod must be number")
 	}
 
-	// Parse optional aggregation parameter
-	aggStr := r.URL.Query().Get("agg")
-	if aggStr == "" {
-		aggStr = "mean" // default to mean
+	if err := validateTilePosition(req.lod, req.x, req.y); err != nil {
+		return req, http.StatusBadRequest, err
 	}
 
-	agg, err := parseAggregationType(aggStr)
+	req.computationId = ps.ByName("tileComputationId")
+	computation, found := core.GetTileComputation(req.computationId)
+	if !found {
+		return req, http.StatusNotFound, fmt.Errorf("Computation '%s' unknown", req.computationId)
+	}
+
+	// Parse optional aggregation parameter, defaulting to the
+	// aggregation each channel declares
+	req.aggStr = r.URL.Query().Get("agg")
+	req.aggs, err = parseAggregations(req.aggStr, computation)
 	if err != nil {
-		http.Error(w, err.Error(), http.StatusBadRequest)
+		return req, http.StatusBadRequest, err
+	}
+
+	// Query parameters the computation declares are passed through
+	req.params, err = core.ParseTileParams(computation.Params, r.URL.Query())
+	if err != nil {
+		return req, http.StatusBadRequest, err
+	}
+
+	return req, http.StatusOK, nil
+}
+
+// immutable reports if the tile is addressed by a full commit hash and
+// so can never change.
+func (req tileRequest) immutable() bool {
+	return plumbing.IsHash(req.commit)
+}
+
+// etag identifies the tile. variant distinguishes representations of
+// the same tile.
+func (req tileRequest) etag(routeId string, variant ...string) string {
+	parts := []string{
+		routeId, req.computationId, req.repoName, req.commit,
+		req.rawLod, req.rawX, req.rawY, req.aggStr, req.params.Key(),
+	}
+	return core.ImmutableETag(append(parts, variant...)...)
+}
+
+func (req tileRequest) getTile(ctx context.Context) ([]int32, error) {
+	return getTile(ctx, req.computationId, req.repoName, plumbing.NewHash(req.commit), req.lod, req.x, req.y, req.aggs, req.params)
+}
+
+func TileHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, status, err := parseTileRequest(r, ps)
+	if err != nil {
+		http.Error(w, err.Error(), status)
 		return
 	}
 
-	tileComputationId := ps.ByName("tileComputationId")
+	w.Header().Set("Vary", "Accept, Accept-Encoding")
+
+	// Tiles for a full commit hash never change. The representation is
+	// part of the ETag since it varies with Accept and Accept-Encoding.
+	etag := req.etag(
+		"api.tile",
+		strconv.FormatBool(acceptsBinaryTile(r)),
+		strconv.FormatBool(acceptsGzip(r)),
+	)
+	if req.immutable() && core.CheckNotModified(w, r, etag) {
+		return
+	}
 
-	tile, err := getTile(r.Context(), tileComputationId, repoName, plumbing.NewHash(commit), lod, x, y, agg)
+	tile, err := req.getTile(r.Context())
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
 	metadata := TileMetadata{
-		X:   x,
-		Y:   y,
-		Lod: lod,
+		X:   req.x,
+		Y:   req.y,
+		Lod: req.lod,
+	}
+
+	if req.immutable() {
+		core.SetImmutableHeaders(w, etag)
+	}
+
+	w, finish := compressResponse(w, r)
+	defer finish()
+
+	if acceptsBinaryTile(r) {
+		if err := writeBinaryTile(w, metadata, tile); err != nil {
+			http.Error(w, "failed to write response", http.StatusInternalServerError)
+		}
+		return
 	}
 
 	w.Header().Set("Content-Type", "application/json")
@@ -365,6 +1003,44 @@ func ListTileComputationsHandler(w http.ResponseWriter, r *http.Request, ps http
 	}
 }
 
+type TileComputationInfo struct {
+	Id     string           `json:"id"`
+	Params []core.TileParam `json:"params"`
+	// Channels is empty for computations with a single value per pixel.
+	Channels []core.TileChannel `json:"channels"`
+	// Agg and Categorical describe computations without channels.
+	Agg         string `json:"agg,omitempty"`
+	Categorical bool   `json:"categorical,omitempty"`
+}
+
+func TileComputationInfoHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	tileComputationId := ps.ByName("tileComputationId")
+	computation, found := core.GetTileComputation(tileComputationId)
+	if !found {
+		http.Error(w, fmt.Sprintf("Computation '%s' unknown", tileComputationId), http.StatusNotFound)
+		return
+	}
+
+	info := TileComputationInfo{
+		Id:          computation.Id,
+		Params:      computation.Params,
+		Channels:    computation.Channels,
+		Agg:         computation.Agg,
+		Categorical: computation.Categorical,
+	}
+	if info.Params == nil {
+		info.Params = []core.TileParam{}
+	}
+	if info.Channels == nil {
+		info.Channels = []core.TileChannel{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(info); err != nil {
+		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
+	}
+}
+
 func ListCommitComputationsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
 	computations := core.ListCommitComputations()
 
@@ -398,12 +1074,18 @@ func CommitComputeHandler(w http.ResponseWriter, r *http.Request, ps httprouter.
 	}
 	hash := plumbing.NewHash(rawHash)
 
+	etag := core.ImmutableETag("api.commit", computationId, repoId, commitHash.String(), hash.String())
+	if core.CheckNotModified(w, r, etag) {
+		return
+	}
+
 	result, err := computation.Execute(r.Context(), repoId, commitHash, hash)
 	if err != nil {
 		http.Error(w, err.Error(), http.StatusInternalServerError)
 		return
 	}
 
+	core.SetImmutableHeaders(w, etag)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(result); err != nil {
 		http.Error(w, "Failed to encode response", http.StatusInternalServerError)
@@ -437,6 +1119,27 @@ func init() {
 		Handler: TileHandler,
 	})
 
+	core.RegisterRoute(core.Route{
+		Id:      "api.tile_png",
+		Method:  http.MethodGet,
+		Path:    "/api/tile.png/:tileComputationId/:repoId/:commit/:lod/:x/:y",
+		Handler: TilePNGHandler,
+	})
+
+	core.RegisterRoute(core.Route{
+		Id:      "api.tile_batch",
+		Method:  http.MethodPost,
+		Path:    "/api/tiles/:repoId/:commit",
+		Handler: TileBatchHandler,
+	})
+
+	core.RegisterRoute(core.Route{
+		Id:      "api.layer_stats",
+		Method:  http.MethodGet,
+		Path:    "/api/stats/:tileComputationId/:repoId/:commit",
+		Handler: LayerStatsHandler,
+	})
+
 	core.RegisterRoute(core.Route{
 		Id:      "api.commit",
 		Method:  http.MethodGet,
@@ -458,6 +1161,13 @@ func init() {
 		Handler: ListTileComputationsHandler,
 	})
 
+	core.RegisterRoute(core.Route{
+		Id:      "api.tile_computation_info",
+		Method:  http.MethodGet,
+		Path:    "/api/tile_computations/:tileComputationId",
+		Handler: TileComputationInfoHandler,
+	})
+
 	core.RegisterRoute(core.Route{
 		Id:      "api.list_commit_computations",
 		Method:  http.MethodGet,
@@ -466,4 +1176,11 @@ func init() {
 	})
 
 	schemas.Register("api.TileMetadata", TileMetadata{})
+	schemas.Register("api.TileComputationInfo", TileComputationInfo{})
+	schemas.Register("api.TileBatchItem", TileBatchItem{})
+	schemas.Register("api.TileBatchRequest", TileBatchRequest{})
+	schemas.Register("api.HistogramBin", HistogramBin{})
+	schemas.Register("api.LayerStats", LayerStats{})
+	schemas.Register("api.WarmLayer", WarmLayer{})
+	schemas.Register("api.WarmProgress", WarmProgress{})
 }
@@ -0,0 +1,635 @@
+package api
+
+import (
+	"bytes"
+	"compress/gzip"
+	"context"
+	"encoding/binary"
+	"fmt"
+	"github.com/chromy/mylar/internal/colormap"
+	"github.com/chromy/mylar/internal/constants"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/index"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/julienschmidt/httprouter"
+	"image"
+	"image/color"
+	"io"
+	"math"
+	"net/http"
+	"net/http/httptest"
+	"slices"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestAggregateValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []int32
+		agg      AggregationType
+		expected int32
+	}{
+		{"Empty", []int32{}, AggregationMean, 0},
+		{"Mean", []int32{1, 2, 3, 6}, AggregationMean, 3},
+		{"Max", []int32{1, 7, 3, 6}, AggregationMax, 7},
+		{"Min", []int32{4, 7, 3, 6}, AggregationMin, 3},
+		{"Mode", []int32{4, 7, 4, 6}, AggregationMode, 4},
+		{"Sum", []int32{1, 0, 1, 1}, AggregationSum, 3},
+		{"Sum saturates", []int32{math.MaxInt32, 1, 0, 0}, AggregationSum, math.MaxInt32},
+		{"Mean large values", []int32{math.MaxInt32, math.MaxInt32, math.MaxInt32, math.MaxInt32}, AggregationMean, math.MaxInt32},
+		{"Mean negative", []int32{math.MinInt32, math.MinInt32}, AggregationMean, math.MinInt32},
+		{"Count", []int32{5, 0, -1, 0}, AggregationCount, 2},
+		{"Distinct", []int32{4, 7, 4, 0}, AggregationDistinct, 2},
+		{"Distinct empty", []int32{0, 0, 0, 0}, AggregationDistinct, 0},
+		{"P50", []int32{4, 1, 3, 2}, AggregationP50, 3},
+		{"P90", []int32{40, 10, 30, 20}, AggregationP90, 37},
+		{"P90 single", []int32{8}, AggregationP90, 8},
+		{"Mean nonzero", []int32{6, 0, 0, 2}, AggregationMean | AggregationNonZero, 4},
+		{"Min nonzero", []int32{6, 0, 3, 2}, AggregationMin | AggregationNonZero, 2},
+		{"Mode nonzero", []int32{0, 0, 5, 5}, AggregationMode | AggregationNonZero, 5},
+		{"Nonzero all empty", []int32{0, 0, 0, 0}, AggregationMean | AggregationNonZero, 0},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := aggregateValues(tt.values, tt.agg); result != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestCombineChildValues(t *testing.T) {
+	tests := []struct {
+		name     string
+		values   []int32
+		agg      AggregationType
+		childAgg AggregationType
+		expected int32
+	}{
+		{"Count sums child counts", []int32{4, 0, 3, 1}, AggregationCount, AggregationCount, 8},
+		{"Distinct of child modes", []int32{7, 7, 9, 0}, AggregationDistinct, AggregationMode, 2},
+		{"Mean of means", []int32{2, 4, 6, 8}, AggregationMean, AggregationMean, 5},
+		{"Max of maxes", []int32{2, 4, 6, 8}, AggregationMax, AggregationMax, 8},
+		{"Distinct keeps modifier", []int32{7, 0, 9, 0}, AggregationDistinct | AggregationNonZero, AggregationMode | AggregationNonZero, 2},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if childAgg := childAggregation(tt.agg); childAgg != tt.childAgg {
+				t.Errorf("Expected child aggregation %d, got %d", tt.childAgg, childAgg)
+			}
+			if result := combineChildValues(tt.values, tt.agg); result != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, result)
+			}
+		})
+	}
+}
+
+func TestParseAggregationType(t *testing.T) {
+	for _, name := range []string{"mean", "mode", "max", "min", "sum", "count", "distinct", "p50", "p90"} {
+		if _, err := parseAggregationType(name); err != nil {
+			t.Errorf("Expected %s to parse, got %v", name, err)
+		}
+	}
+
+	if _, err := parseAggregationType("median"); err == nil {
+		t.Error("Expected error for unknown aggregation")
+	}
+
+	agg, err := parseAggregationType("mode:nonzero")
+	if err != nil || agg != AggregationMode|AggregationNonZero {
+		t.Errorf("Expected mode:nonzero to parse, got %d %v", agg, err)
+	}
+
+	if _, err := parseAggregationType("mode:sometimes"); err == nil {
+		t.Error("Expected error for unknown modifier")
+	}
+}
+
+func TestParseAggregations(t *testing.T) {
+	single := core.TileComputation{Id: "single"}
+	multi := core.TileComputation{
+		Id:       "multi",
+		Channels: []core.TileChannel{{Name: "a", Agg: "mode"}, {Name: "b"}, {Name: "c", Agg: "max"}},
+	}
+	categorical := core.TileComputation{Id: "categorical", Agg: "max", Categorical: true}
+	categoricalChannel := core.TileComputation{
+		Id:       "categoricalChannel",
+		Channels: []core.TileChannel{{Name: "a", Agg: "mode", Categorical: true}, {Name: "b"}},
+	}
+
+	tests := []struct {
+		name        string
+		s           string
+		computation core.TileComputation
+		expected    []AggregationType
+		wantErr     bool
+	}{
+		{"Single default", "", single, []AggregationType{AggregationMean}, false},
+		{"Single", "max", single, []AggregationType{AggregationMax}, false},
+		{"Channel defaults", "", multi, []AggregationType{AggregationMode, AggregationMean, AggregationMax}, false},
+		{"One for every channel", "min", multi, []AggregationType{AggregationMin, AggregationMin, AggregationMin}, false},
+		{"Per channel", "mode:nonzero,p50,sum", multi, []AggregationType{AggregationMode | AggregationNonZero, AggregationP50, AggregationSum}, false},
+		{"Too few", "mode,mean", multi, nil, true},
+		{"Too many for single", "mode,mean", single, nil, true},
+		{"Unknown", "mode,median,max", multi, nil, true},
+		{"Categorical default", "", categorical, []AggregationType{AggregationMax}, false},
+		{"Categorical mode", "mode", categorical, []AggregationType{AggregationMode}, false},
+		{"Categorical count", "count", categorical, []AggregationType{AggregationCount}, false},
+		{"Categorical mean", "mean", categorical, nil, true},
+		{"Categorical sum", "sum:nonzero", categorical, nil, true},
+		{"Categorical p90", "p90", categorical, nil, true},
+		{"Categorical channel", "mode,mean", categoricalChannel, []AggregationType{AggregationMode, AggregationMean}, false},
+		{"Categorical channel mean", "mean,mean", categoricalChannel, nil, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			aggs, err := parseAggregations(tt.s, tt.computation)
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %v", aggs)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseAggregations failed: %v", err)
+			}
+			if aggregationsKey(aggs) != aggregationsKey(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, aggs)
+			}
+		})
+	}
+}
+
+func TestChannelPlane(t *testing.T) {
+	planeSize := constants.TileSize * constants.TileSize
+	tile := make([]int32, 2*planeSize)
+	tile[planeSize] = 7
+
+	if plane := channelPlane(tile, 1); len(plane) != planeSize || plane[0] != 7 {
+		t.Errorf("Expected the second plane, got %d values starting %d", len(plane), plane[0])
+	}
+	if plane := channelPlane(tile, 2); len(plane) != planeSize || plane[0] != 0 {
+		t.Error("Expected an empty plane past the last channel")
+	}
+
+	computation := core.TileComputation{Channels: []core.TileChannel{{Name: "a"}, {Name: "b"}}}
+	for _, raw := range []string{"-1", "2", "x"} {
+		if _, err := parseChannel(raw, computation); err == nil {
+			t.Errorf("Expected error for channel %q", raw)
+		}
+	}
+	if channel, err := parseChannel("1", computation); err != nil || channel != 1 {
+		t.Errorf("Expected channel 1, got %d %v", channel, err)
+	}
+}
+
+func TestAcceptsBinaryTile(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		expected bool
+	}{
+		{"No header", "", false},
+		{"JSON", "application/json", false},
+		{"Octet stream", "application/octet-stream", true},
+		{"Octet stream in list", "application/json;q=0.5, application/octet-stream", true},
+		{"Octet stream with params", "application/octet-stream;q=1", true},
+		{"Wildcard", "*/*", false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/tile/x", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept", tt.accept)
+			}
+			if result := acceptsBinaryTile(r); result != tt.expected {
+				t.Errorf("Expected %v for %q, got %v", tt.expected, tt.accept, result)
+			}
+		})
+	}
+}
+
+func TestWriteBinaryTile(t *testing.T) {
+	tile := make([]int32, constants.TileSize*constants.TileSize)
+	tile[0] = 1
+	tile[1] = -2
+	tile[len(tile)-1] = math.MaxInt32
+
+	w := httptest.NewRecorder()
+	if err := writeBinaryTile(w, TileMetadata{X: 3, Y: 4, Lod: 2}, tile); err != nil {
+		t.Fatalf("writeBinaryTile failed: %v", err)
+	}
+
+	if w.Header().Get("Content-Type") != "application/octet-stream" {
+		t.Errorf("Unexpected content type %s", w.Header().Get("Content-Type"))
+	}
+
+	body := w.Body.Bytes()
+	if len(body) != 24+len(tile)*4 {
+		t.Fatalf("Expected %d bytes, got %d", 24+len(tile)*4, len(body))
+	}
+
+	var header binaryTileHeader
+	if err := binary.Read(bytes.NewReader(body), binary.LittleEndian, &header); err != nil {
+		t.Fatalf("Failed to read header: %v", err)
+	}
+	if header.Magic != binaryTileMagic || header.Version != binaryTileVersion {
+		t.Errorf("Unexpected magic/version %v %d", header.Magic, header.Version)
+	}
+	if header.TileSize != constants.TileSize || header.Length != uint32(len(tile)) {
+		t.Errorf("Unexpected size/length %d %d", header.TileSize, header.Length)
+	}
+	if header.X != 3 || header.Y != 4 || header.Lod != 2 {
+		t.Errorf("Unexpected position %d %d %d", header.X, header.Y, header.Lod)
+	}
+
+	payload := body[24:]
+	for _, i := range []int{0, 1, len(tile) - 1} {
+		v := int32(binary.LittleEndian.Uint32(payload[i*4:]))
+		if v != tile[i] {
+			t.Errorf("Expected pixel %d to be %d, got %d", i, tile[i], v)
+		}
+	}
+}
+
+func TestParseTileRequestPosition(t *testing.T) {
+	tests := []struct {
+		name           string
+		lod            string
+		x              string
+		y              string
+		expectedStatus int
+	}{
+		{"Origin", "0", "0", "0", http.StatusOK},
+		{"Max lod", strconv.Itoa(constants.MaxLod), "0", "0", http.StatusOK},
+		{"Far away", "0", "1000000", "1000000", http.StatusOK},
+		{"Negative lod", "-1", "0", "0", http.StatusBadRequest},
+		{"Lod too large", strconv.Itoa(constants.MaxLod + 1), "0", "0", http.StatusBadRequest},
+		{"Negative x", "0", "-1", "0", http.StatusBadRequest},
+		{"Negative y", "0", "0", "-1", http.StatusBadRequest},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			_, status, err := parseTileRequest(r, httprouter.Params{
+				{Key: "repoId", Value: "test"},
+				{Key: "commit", Value: "HEAD"},
+				{Key: "tileComputationId", Value: "fileHash"},
+				{Key: "lod", Value: tt.lod},
+				{Key: "x", Value: tt.x},
+				{Key: "y", Value: tt.y},
+			})
+			if status != tt.expectedStatus {
+				t.Errorf("Expected status %d, got %d (%v)", tt.expectedStatus, status, err)
+			}
+		})
+	}
+}
+
+func TestAcceptsGzip(t *testing.T) {
+	tests := []struct {
+		name     string
+		accept   string
+		expected bool
+	}{
+		{"No header", "", false},
+		{"Identity", "identity", false},
+		{"Gzip", "gzip", true},
+		{"Browser default", "gzip, deflate, br, zstd", true},
+		{"Gzip with weight", "br;q=1.0, gzip;q=0.8", true},
+		{"Gzip refused", "gzip;q=0", false},
+		{"Gzip refused decimal", "gzip;q=0.0", false},
+		{"Gzip refused with spaces", "gzip ; q = 0", false},
+		{"Gzip refused upper case", "GZIP;Q=0", false},
+		{"Gzip refused after params", "gzip;level=1;q=0", false},
+		{"Gzip bad weight", "gzip;q=high", false},
+		{"X-gzip", "x-gzip", true},
+		{"Wildcard", "*", true},
+		{"Wildcard refused", "*;q=0", false},
+		{"Wildcard with other", "br, *;q=0.1", true},
+		{"Gzip refused despite wildcard", "gzip;q=0, *", false},
+		{"Gzip accepted despite wildcard refused", "gzip;q=0.5, *;q=0", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/api/tile/x", nil)
+			if tt.accept != "" {
+				r.Header.Set("Accept-Encoding", tt.accept)
+			}
+			if result := acceptsGzip(r); result != tt.expected {
+				t.Errorf("Expected %v for %q, got %v", tt.expected, tt.accept, result)
+			}
+		})
+	}
+}
+
+func TestCompressResponse(t *testing.T) {
+	r := httptest.NewRequest(http.MethodGet, "/api/tile/x", nil)
+	r.Header.Set("Accept-Encoding", "gzip")
+
+	recorder := httptest.NewRecorder()
+	w, finish := compressResponse(recorder, r)
+	tile := make([]int32, constants.TileSize*constants.TileSize)
+	if err := writeBinaryTile(w, TileMetadata{}, tile); err != nil {
+		t.Fatalf("writeBinaryTile failed: %v", err)
+	}
+	finish()
+
+	if recorder.Header().Get("Content-Encoding") != "gzip" {
+		t.Fatalf("Expected gzip content encoding")
+	}
+	if recorder.Body.Len() >= len(tile) {
+		t.Errorf("Expected blank tile to compress, got %d bytes", recorder.Body.Len())
+	}
+
+	gz, err := gzip.NewReader(recorder.Body)
+	if err != nil {
+		t.Fatalf("Failed to open gzip body: %v", err)
+	}
+	body, err := io.ReadAll(gz)
+	if err != nil {
+		t.Fatalf("Failed to read gzip body: %v", err)
+	}
+	if len(body) != 24+len(tile)*4 {
+		t.Errorf("Expected %d bytes, got %d", 24+len(tile)*4, len(body))
+	}
+}
+
+func TestParseValueRange(t *testing.T) {
+	values := []int32{0, 10, 20}
+	tests := []struct {
+		name        string
+		rawMin      string
+		rawMax      string
+		expected    colormap.Range
+		expectError bool
+	}{
+		{"auto", "", "", colormap.Range{Min: 10, Max: 20}, false},
+		{"explicit", "0", "100", colormap.Range{Min: 0, Max: 100}, false},
+		{"only min", "15", "", colormap.Range{Min: 15, Max: 20}, false},
+		{"only min above values", "50", "", colormap.Range{Min: 50, Max: 50}, false},
+		{"only max below values", "", "5", colormap.Range{Min: 5, Max: 5}, false},
+		{"inverted", "10", "5", colormap.Range{}, true},
+		{"not a number", "x", "", colormap.Range{}, true},
+		{"overflow", "", "9999999999", colormap.Range{}, true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			actual, err := parseValueRange(test.rawMin, test.rawMax, values)
+			if test.expectError {
+				if err == nil {
+					t.Errorf("Expected error got %v", actual)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("Unexpected error: %v", err)
+			}
+			if actual != test.expected {
+				t.Errorf("Expected %v got %v", test.expected, actual)
+			}
+		})
+	}
+}
+
+func TestParseColormap(t *testing.T) {
+	for _, name := range []string{"", "viridis", "categorical", "diverging"} {
+		if _, err := parseColormap(name); err != nil {
+			t.Errorf("parseColormap(%q) failed: %v", name, err)
+		}
+	}
+	if _, err := parseColormap("rainbow"); err == nil {
+		t.Errorf("Expected unknown colormap to fail")
+	}
+}
+
+func TestMapLod(t *testing.T) {
+	tests := []struct {
+		gridSide int64
+		size     int
+		expected int64
+	}{
+		{16, 1024, 0},
+		{64, 16, 0},
+		{1024, 1024, 0},
+		{1024, 512, 1},
+		{1024, 300, 2},
+		{1024, 10, 4},
+		{4096, 4096, 0},
+	}
+
+	for _, test := range tests {
+		if actual := mapLod(test.gridSide, test.size); actual != test.expected {
+			t.Errorf("mapLod(%d, %d) = %d, expected %d", test.gridSide, test.size, actual, test.expected)
+		}
+	}
+}
+
+func TestWorldDoublings(t *testing.T) {
+	tests := []struct {
+		gridSide  int64
+		worldSide int64
+		expected  int
+		wantErr   bool
+	}{
+		{16, 0, 0, false},
+		{16, 16, 0, false},
+		{16, 8, 0, false},
+		{16, 32, 1, false},
+		{16, 128, 3, false},
+		{16, 48, 0, true},
+	}
+
+	for _, test := range tests {
+		actual, err := worldDoublings(test.gridSide, test.worldSide)
+		if test.wantErr {
+			if err == nil {
+				t.Errorf("worldDoublings(%d, %d): expected error", test.gridSide, test.worldSide)
+			}
+			continue
+		}
+		if err != nil || actual != test.expected {
+			t.Errorf("worldDoublings(%d, %d) = %d, %v, expected %d", test.gridSide, test.worldSide, actual, err, test.expected)
+		}
+	}
+}
+
+func TestPlaceInWorld(t *testing.T) {
+	red := color.NRGBA{R: 255, A: 255}
+	blue := color.NRGBA{B: 255, A: 255}
+	src := image.NewNRGBA(image.Rect(0, 0, 2, 2))
+	src.SetNRGBA(1, 0, red)
+	src.SetNRGBA(0, 1, blue)
+
+	tests := []struct {
+		name      string
+		transpose bool
+		expected  map[image.Point]color.NRGBA
+	}{
+		{"Straight", false, map[image.Point]color.NRGBA{{1, 0}: red, {0, 1}: blue}},
+		{"Transposed", true, map[image.Point]color.NRGBA{{0, 1}: red, {1, 0}: blue}},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			dst := placeInWorld(src, 4, test.transpose)
+			if dst.Bounds() != image.Rect(0, 0, 4, 4) {
+				t.Fatalf("Expected 4x4 image, got %v", dst.Bounds())
+			}
+			for y := 0; y < 4; y++ {
+				for x := 0; x < 4; x++ {
+					expected := test.expected[image.Pt(x, y)]
+					if actual := dst.NRGBAAt(x, y); actual != expected {
+						t.Errorf("Pixel (%d, %d): expected %v, got %v", x, y, expected, actual)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestStatsBin(t *testing.T) {
+	for _, v := range []int32{0, 1, 2, 3, 4, 1000, math.MaxInt32, -1, -2, -3, -1000, math.MinInt32} {
+		bin := statsBin(v)
+		if bin < 0 || bin >= statsBinCount {
+			t.Fatalf("statsBin(%d) = %d out of range", v, bin)
+		}
+		lo, hi := statsBinBounds(bin)
+		if int64(v) < lo || int64(v) > hi {
+			t.Errorf("Value %d not within bounds [%d, %d] of bin %d", v, lo, hi, bin)
+		}
+	}
+}
+
+func TestTileStatsMerge(t *testing.T) {
+	a := newTileStats()
+	for _, v := range []int32{1, 2, 3} {
+		a.add(v)
+	}
+	b := newTileStats()
+	b.add(-4)
+
+	merged := newTileStats()
+	merged.merge(a)
+	merged.merge(b)
+	merged.merge(newTileStats())
+
+	stats := merged.toLayerStats()
+	if stats.Count != 4 || stats.Min != -4 || stats.Max != 3 || stats.Mean != 0.5 {
+		t.Errorf("Unexpected stats %+v", stats)
+	}
+
+	var histogramCount int64
+	for _, bin := range stats.Histogram {
+		histogramCount += bin.Count
+	}
+	if histogramCount != 4 || len(stats.Histogram) != 3 {
+		t.Errorf("Unexpected histogram %+v", stats.Histogram)
+	}
+
+	empty := newTileStats().toLayerStats()
+	if empty.Count != 0 || empty.Min != 0 || empty.Max != 0 || len(empty.Histogram) != 0 {
+		t.Errorf("Unexpected empty stats %+v", empty)
+	}
+}
+
+func TestMergeDistinct(t *testing.T) {
+	if merged := mergeDistinct(nil, []int32{}); merged != nil {
+		t.Errorf("Expected no values, got %v", merged)
+	}
+	if merged := mergeDistinct([]int32{1, 5}, []int32{3, 5}, nil, []int32{1}); !slices.Equal(merged, []int32{1, 3, 5}) {
+		t.Errorf("Expected [1 3 5], got %v", merged)
+	}
+
+	// Sets at the cap stay at the cap when merged.
+	var full, other []int32
+	for i := int32(1); i <= maxDistinctValues; i++ {
+		full = append(full, i*2)
+		other = append(other, i*2+1)
+	}
+	merged := mergeDistinct(full, other)
+	if len(merged) != maxDistinctValues {
+		t.Fatalf("Expected %d values, got %d", maxDistinctValues, len(merged))
+	}
+	if !slices.IsSorted(merged) || merged[0] != 2 || merged[1] != 3 {
+		t.Errorf("Expected the smallest values to be kept, got %v", merged)
+	}
+}
+
+func TestDistinctMacroTile(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Lots of two line files followed by one big file so pixels at
+	// LOD 2, which cover 16 lines, see up to eight files.
+	files := map[string]string{"z.txt": strings.Repeat("z\n", 16400)}
+	for i := 0; i < 20; i++ {
+		files[fmt.Sprintf("a%02d.txt", i)] = fmt.Sprintf("%d\n%d", i, i)
+	}
+	commit := repotest.CommitFiles(t, repository, dir, "change", time.Unix(1700000000, 0), files)
+
+	ctx := context.Background()
+	if err := repo.AddFromPath(ctx, "test:distinct", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	layout, err := index.GetLayoutForCommit(ctx, "test:distinct", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	const lod = 2
+	if layout.GridSideLength() != constants.TileSize<<lod {
+		t.Fatalf("Expected a single tile at LOD %d, grid side is %d", lod, layout.GridSideLength())
+	}
+	tree, err := repo.CommitToTree(ctx, "test:distinct", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	idx, err := index.GetIndex(ctx, "test:distinct", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Count the files under each pixel straight from the lines.
+	hashes := make(map[int]map[plumbing.Hash]bool)
+	for line := int64(0); line < int64(layout.LineCount); line++ {
+		world := utils.LineToWorld(utils.LinePosition(line), layout)
+		pixel := int(world.Y>>lod)*constants.TileSize + int(world.X>>lod)
+		if hashes[pixel] == nil {
+			hashes[pixel] = make(map[plumbing.Hash]bool)
+		}
+		hashes[pixel][idx.FindFileByLine(line).Hash] = true
+	}
+
+	tile, err := getTile(ctx, "fileHash", "test:distinct", commit, lod, 0, 0, []AggregationType{AggregationDistinct}, core.TileParams{})
+	if err != nil {
+		t.Fatalf("getTile failed: %v", err)
+	}
+	var most int32
+	for pixel, value := range tile {
+		if expected := int32(len(hashes[pixel])); value != expected {
+			t.Errorf("Pixel %d: expected %d distinct files, got %d", pixel, expected, value)
+		}
+		most = max(most, value)
+	}
+	if most <= 4 {
+		t.Errorf("Expected a pixel with more than 4 distinct files, got at most %d", most)
+	}
+}
@@ -0,0 +1,171 @@
+package api
+
+import (
+	"context"
+	"encoding/binary"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/sync/errgroup"
+	"io"
+	"net/http"
+	"net/url"
+	"sync"
+)
+
+// A batch response is a stream of frames, one per requested tile, in
+// the order the tiles finish. Each frame is:
+//
+//	uint32 length of the rest of the frame (excluding padding)
+//	uint32 index of the tile in the request
+//	uint32 status (tileBatchStatusOk or tileBatchStatusError)
+//	payload: a binary tile (see binaryTileHeader) or a UTF-8 error
+//	zero padding up to a multiple of 4 bytes
+//
+// All integers are little-endian. Padding keeps every binary tile
+// payload 4-byte aligned.
+const (
+	tileBatchStatusOk    uint32 = 0
+	tileBatchStatusError uint32 = 1
+)
+
+const tileBatchFrameHeaderSize = 12
+
+const maxTileBatchSize = 256
+
+const maxConcurrentBatchTiles = 8
+
+type TileBatchItem struct {
+	Computation string            `json:"computation"`
+	Lod         int64             `json:"lod"`
+	X           int64             `json:"x"`
+	Y           int64             `json:"y"`
+	Agg         string            `json:"agg,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+type TileBatchRequest struct {
+	Tiles []TileBatchItem `json:"tiles"`
+}
+
+// batchTile computes a single tile of a batch validating it the same
+// way TileHandler validates a single tile request.
+func batchTile(ctx context.Context, repoName string, commit plumbing.Hash, item TileBatchItem) ([]byte, error) {
+	computation, found := core.GetTileComputation(item.Computation)
+	if !found {
+		return nil, fmt.Errorf("computation '%s' unknown", item.Computation)
+	}
+
+	aggs, err := parseAggregations(item.Agg, computation)
+	if err != nil {
+		return nil, err
+	}
+
+	query := url.Values{}
+	for name, value := range item.Params {
+		query.Set(name, value)
+	}
+	params, err := core.ParseTileParams(computation.Params, query)
+	if err != nil {
+		return nil, err
+	}
+
+	tile, err := getTile(ctx, item.Computation, repoName, commit, item.Lod, item.X, item.Y, aggs, params)
+	if err != nil {
+		return nil, err
+	}
+
+	metadata := TileMetadata{
+		X:   item.X,
+		Y:   item.Y,
+		Lod: item.Lod,
+	}
+	return encodeBinaryTile(metadata, tile)
+}
+
+func writeTileBatchFrame(w io.Writer, index int, status uint32, payload []byte) error {
+	frameLength := tileBatchFrameHeaderSize - 4 + len(payload)
+	padding := (4 - frameLength%4) % 4
+
+	frame := make([]byte, tileBatchFrameHeaderSize, tileBatchFrameHeaderSize+len(payload)+padding)
+	binary.LittleEndian.PutUint32(frame[0:4], uint32(frameLength))
+	binary.LittleEndian.PutUint32(frame[4:8], uint32(index))
+	binary.LittleEndian.PutUint32(frame[8:12], status)
+	frame = append(frame, payload...)
+	frame = append(frame, make([]byte, padding)...)
+
+	_, err := w.Write(frame)
+	return err
+}
+
+func TileBatchHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repoId")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	rawCommit := ps.ByName("commit")
+	if !plumbing.IsHash(rawCommit) {
+		http.Error(w, fmt.Sprintf("Could not parse commit hash '%s'", rawCommit), http.StatusBadRequest)
+		return
+	}
+	commit := plumbing.NewHash(rawCommit)
+
+	var request TileBatchRequest
+	if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+		http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+		return
+	}
+	if len(request.Tiles) > maxTileBatchSize {
+		http.Error(w, fmt.Sprintf("at most %d tiles may be requested at once", maxTileBatchSize), http.StatusBadRequest)
+		return
+	}
+	for i, item := range request.Tiles {
+		if err := validateTilePosition(item.Lod, item.X, item.Y); err != nil {
+			http.Error(w, fmt.Sprintf("tile %d: %s", i, err), http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Vary", "Accept-Encoding")
+	w.Header().Set("Content-Type", binaryTileContentType)
+
+	w, finish := compressResponse(w, r)
+	defer finish()
+
+	// Frames are written as soon as each tile is ready. Failed tiles
+	// get an error frame rather than failing the batch. Only a failed
+	// write (e.g. the client going away) stops the remaining tiles.
+	var mu sync.Mutex
+	g, ctx := errgroup.WithContext(r.Context())
+	g.SetLimit(maxConcurrentBatchTiles)
+
+	for i, item := range request.Tiles {
+		i, item := i, item // capture loop variables
+		g.Go(func() error {
+			status := tileBatchStatusOk
+			payload, err := batchTile(ctx, repoName, commit, item)
+			if err != nil {
+				status = tileBatchStatusError
+				payload = []byte(err.Error())
+			}
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err := writeTileBatchFrame(w, i, status, payload); err != nil {
+				return err
+			}
+			if flusher, ok := w.(http.Flusher); ok {
+				flusher.Flush()
+			}
+			return nil
+		})
+	}
+
+	// The status has already been sent so there is nobody to report a
+	// write error to.
+	g.Wait()
+}
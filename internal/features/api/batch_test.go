@@ -0,0 +1,125 @@
+package api
+
+import (
+	"bytes"
+	"encoding/binary"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+type testFrame struct {
+	index   uint32
+	status  uint32
+	payload []byte
+}
+
+func readTestFrames(t *testing.T, data []byte) []testFrame {
+	var frames []testFrame
+	for len(data) > 0 {
+		if len(data) < tileBatchFrameHeaderSize {
+			t.Fatalf("Truncated frame header: %d bytes", len(data))
+		}
+		length := int(binary.LittleEndian.Uint32(data[0:4]))
+		end := 4 + length
+		padded := end + (4-end%4)%4
+		if len(data) < padded {
+			t.Fatalf("Truncated frame: want %d bytes got %d", padded, len(data))
+		}
+		frames = append(frames, testFrame{
+			index:   binary.LittleEndian.Uint32(data[4:8]),
+			status:  binary.LittleEndian.Uint32(data[8:12]),
+			payload: data[tileBatchFrameHeaderSize:end],
+		})
+		data = data[padded:]
+	}
+	return frames
+}
+
+func TestWriteTileBatchFrame(t *testing.T) {
+	tile := []int32{1, 2, 3}
+	encoded, err := encodeBinaryTile(TileMetadata{X: 1, Y: 2, Lod: 3}, tile)
+	if err != nil {
+		t.Fatalf("encodeBinaryTile failed: %v", err)
+	}
+
+	var buf bytes.Buffer
+	if err := writeTileBatchFrame(&buf, 0, tileBatchStatusError, []byte("bad")); err != nil {
+		t.Fatalf("writeTileBatchFrame failed: %v", err)
+	}
+	if buf.Len()%4 != 0 {
+		t.Errorf("Expected frame padded to 4 bytes, got %d", buf.Len())
+	}
+	if err := writeTileBatchFrame(&buf, 1, tileBatchStatusOk, encoded); err != nil {
+		t.Fatalf("writeTileBatchFrame failed: %v", err)
+	}
+
+	frames := readTestFrames(t, buf.Bytes())
+	if len(frames) != 2 {
+		t.Fatalf("Expected 2 frames got %d", len(frames))
+	}
+	if frames[0].index != 0 || frames[0].status != tileBatchStatusError || string(frames[0].payload) != "bad" {
+		t.Errorf("Unexpected error frame %+v", frames[0])
+	}
+	if frames[1].index != 1 || frames[1].status != tileBatchStatusOk || !bytes.Equal(frames[1].payload, encoded) {
+		t.Errorf("Unexpected tile frame %+v", frames[1])
+	}
+}
+
+func TestTileBatchHandler(t *testing.T) {
+	commit := strings.Repeat("a", 40)
+	ps := httprouter.Params{
+		{Key: "repoId", Value: "test"},
+		{Key: "commit", Value: commit},
+	}
+
+	tests := []struct {
+		name           string
+		commit         string
+		body           string
+		expectedStatus int
+		expectedFrames int
+	}{
+		{"invalid body", commit, "{", http.StatusBadRequest, 0},
+		{"invalid commit", "main", `{"tiles":[]}`, http.StatusBadRequest, 0},
+		{"empty batch", commit, `{"tiles":[]}`, http.StatusOK, 0},
+		{"negative lod", commit, `{"tiles":[{"computation":"fileHash","lod":-1}]}`, http.StatusBadRequest, 0},
+		{"lod too large", commit, `{"tiles":[{"computation":"fileHash","lod":57}]}`, http.StatusBadRequest, 0},
+		{"negative x", commit, `{"tiles":[{"computation":"fileHash","x":-1}]}`, http.StatusBadRequest, 0},
+		{"negative y", commit, `{"tiles":[{"computation":"fileHash","y":-1}]}`, http.StatusBadRequest, 0},
+		{"per tile errors", commit, `{"tiles":[{"computation":"doesNotExist"},{"computation":"doesNotExist","agg":"bad"}]}`, http.StatusOK, 2},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			ps[1].Value = test.commit
+			r := httptest.NewRequest(http.MethodPost, "/api/tiles/test/"+test.commit, strings.NewReader(test.body))
+			w := httptest.NewRecorder()
+			TileBatchHandler(w, r, ps)
+
+			if w.Code != test.expectedStatus {
+				t.Fatalf("Expected status %d got %d", test.expectedStatus, w.Code)
+			}
+			if w.Code != http.StatusOK {
+				return
+			}
+
+			frames := readTestFrames(t, w.Body.Bytes())
+			if len(frames) != test.expectedFrames {
+				t.Fatalf("Expected %d frames got %d", test.expectedFrames, len(frames))
+			}
+			seen := make(map[uint32]bool)
+			for _, frame := range frames {
+				if frame.status != tileBatchStatusError {
+					t.Errorf("Expected error frame got status %d", frame.status)
+				}
+				seen[frame.index] = true
+			}
+			if len(seen) != test.expectedFrames {
+				t.Errorf("Expected a frame for every tile, got indexes %v", seen)
+			}
+		})
+	}
+}
@@ -0,0 +1,130 @@
+package api
+
+import (
+	"bytes"
+	"fmt"
+	"github.com/chromy/mylar/internal/colormap"
+	"github.com/chromy/mylar/internal/constants"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/julienschmidt/httprouter"
+	"image/png"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+const defaultColormap = "viridis"
+
+// parseValueRange reads the range of values to color. Either end which
+// isn't given is taken from the non-zero values (clamped so the range
+// stays valid).
+func parseValueRange(rawMin string, rawMax string, values []int32) (colormap.Range, error) {
+	valueRange := colormap.AutoRange(values)
+
+	if rawMin != "" {
+		parsed, err := strconv.ParseInt(rawMin, 10, 32)
+		if err != nil {
+			return valueRange, fmt.Errorf("min must be a 32-bit number")
+		}
+		valueRange.Min = int32(parsed)
+	}
+
+	if rawMax != "" {
+		parsed, err := strconv.ParseInt(rawMax, 10, 32)
+		if err != nil {
+			return valueRange, fmt.Errorf("max must be a 32-bit number")
+		}
+		valueRange.Max = int32(parsed)
+	}
+
+	if valueRange.Min > valueRange.Max {
+		switch {
+		case rawMin != "" && rawMax != "":
+			return valueRange, fmt.Errorf("min must not be greater than max")
+		case rawMin != "":
+			valueRange.Max = valueRange.Min
+		default:
+			valueRange.Min = valueRange.Max
+		}
+	}
+	return valueRange, nil
+}
+
+func parseColormap(name string) (colormap.Colormap, error) {
+	if name == "" {
+		name = defaultColormap
+	}
+	cm, found := colormap.Get(name)
+	if !found {
+		return nil, fmt.Errorf("colormap must be one of %s", strings.Join(colormap.Names(), ", "))
+	}
+	return cm, nil
+}
+
+// TilePNGHandler renders a tile as a PNG using the colormap given by
+// ?colormap= over the values ?min=..?max=. Empty pixels are
+// transparent. For multi-channel computations ?channel= picks the
+// channel drawn.
+func TilePNGHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	req, status, err := parseTileRequest(r, ps)
+	if err != nil {
+		http.Error(w, err.Error(), status)
+		return
+	}
+
+	query := r.URL.Query()
+	rawColormap := query.Get("colormap")
+	rawMin := query.Get("min")
+	rawMax := query.Get("max")
+	rawChannel := query.Get("channel")
+
+	computation, _ := core.GetTileComputation(req.computationId)
+	channel, err := parseChannel(rawChannel, computation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	cm, err := parseColormap(rawColormap)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	// Validate the range up front so bad requests don't compute tiles.
+	if _, err := parseValueRange(rawMin, rawMax, nil); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	etag := req.etag("api.tile_png", rawColormap, rawMin, rawMax, strconv.Itoa(channel))
+	if req.immutable() && core.CheckNotModified(w, r, etag) {
+		return
+	}
+
+	tile, err := req.getTile(r.Context())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	tile = channelPlane(tile, channel)
+
+	valueRange, err := parseValueRange(rawMin, rawMax, tile)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	var buf bytes.Buffer
+	img := colormap.Render(tile, constants.TileSize, cm, valueRange)
+	if err := png.Encode(&buf, img); err != nil {
+		http.Error(w, "failed to encode png", http.StatusInternalServerError)
+		return
+	}
+
+	if req.immutable() {
+		core.SetImmutableHeaders(w, etag)
+	}
+	w.Header().Set("Content-Type", "image/png")
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,268 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromy/mylar/internal/colormap"
+	"github.com/chromy/mylar/internal/constants"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/sync/errgroup"
+	"image"
+	"math"
+	"net/url"
+)
+
+const maxConcurrentRenderTiles = 8
+
+// MapRenderOptions describes an image of a whole commit. Agg, Channel,
+// Colormap, Min and Max take the same values as the tile.png query
+// parameters.
+type MapRenderOptions struct {
+	RepoId      string
+	Commit      plumbing.Hash
+	Computation string
+	Params      map[string]string
+	Agg         string
+	// Channel is the channel drawn for multi-channel computations.
+	Channel  int
+	Colormap string
+	Min      string
+	Max      string
+	// Layout is the layout of Commit.
+	Layout utils.TileLayout
+	// WorldSide, if larger than Layout's grid side, draws the map where
+	// it would sit in a world of that side so maps of commits with
+	// different line counts line up. It must be the grid side times a
+	// power of two.
+	WorldSide int64
+	// Size is the side of the output image in pixels.
+	Size int
+}
+
+// mapLod picks the LOD to render a world of side gridSide into an image
+// of side size. We use the most detailed LOD whose tiles don't give
+// more pixels than needed, never going past the root tile.
+func mapLod(gridSide int64, size int) int64 {
+	var lod int64
+	for gridSide>>(lod+1) >= constants.TileSize && gridSide>>lod > int64(size) {
+		lod++
+	}
+	return lod
+}
+
+// worldDoublings is the number of times gridSide doubles to reach
+// worldSide.
+func worldDoublings(gridSide int64, worldSide int64) (int, error) {
+	doublings := 0
+	for gridSide<<doublings < worldSide {
+		doublings++
+	}
+	if gridSide<<doublings != max(worldSide, gridSide) {
+		return 0, fmt.Errorf("world side %d must be grid side %d times a power of two", worldSide, gridSide)
+	}
+	return doublings, nil
+}
+
+// mapRender is a validated MapRenderOptions.
+type mapRender struct {
+	options   MapRenderOptions
+	aggs      []AggregationType
+	params    core.TileParams
+	cm        colormap.Colormap
+	lod       int64
+	side      int64
+	size      int
+	doublings int
+}
+
+func newMapRender(options MapRenderOptions) (*mapRender, error) {
+	if options.Size <= 0 {
+		return nil, fmt.Errorf("size must be positive")
+	}
+	gridSide := options.Layout.GridSideLength()
+	if gridSide <= 0 {
+		return nil, fmt.Errorf("grid side must be positive")
+	}
+
+	computation, found := core.GetTileComputation(options.Computation)
+	if !found {
+		return nil, fmt.Errorf("computation '%s' unknown", options.Computation)
+	}
+
+	aggs, err := parseAggregations(options.Agg, computation)
+	if err != nil {
+		return nil, err
+	}
+	if options.Channel < 0 || options.Channel >= computation.ChannelCount() {
+		return nil, fmt.Errorf("channel must be less than %d", computation.ChannelCount())
+	}
+
+	query := url.Values{}
+	for name, value := range options.Params {
+		query.Set(name, value)
+	}
+	params, err := core.ParseTileParams(computation.Params, query)
+	if err != nil {
+		return nil, err
+	}
+
+	cm, err := parseColormap(options.Colormap)
+	if err != nil {
+		return nil, err
+	}
+	if _, err := parseValueRange(options.Min, options.Max, nil); err != nil {
+		return nil, err
+	}
+
+	doublings, err := worldDoublings(gridSide, options.WorldSide)
+	if err != nil {
+		return nil, err
+	}
+
+	// In a larger world the map only covers part of the image.
+	size := max(options.Size>>doublings, 1)
+	lod := mapLod(gridSide, size)
+	return &mapRender{
+		options:   options,
+		aggs:      aggs,
+		params:    params,
+		cm:        cm,
+		lod:       lod,
+		side:      options.Layout.TilesPerSide(lod),
+		size:      size,
+		doublings: doublings,
+	}, nil
+}
+
+// tiles fetches the drawn channel of every tile of the map in row
+// major order.
+func (m *mapRender) tiles(ctx context.Context) ([][]int32, error) {
+	options := m.options
+	tiles := make([][]int32, m.side*m.side)
+	g, ctx := errgroup.WithContext(ctx)
+	g.SetLimit(maxConcurrentRenderTiles)
+	for y := int64(0); y < m.side; y++ {
+		for x := int64(0); x < m.side; x++ {
+			x, y := x, y // capture loop variables
+			g.Go(func() error {
+				tile, err := getTile(ctx, options.Computation, options.RepoId, options.Commit, m.lod, x, y, m.aggs, m.params)
+				if err != nil {
+					return fmt.Errorf("tile (%d, %d) at LOD %d: %w", x, y, m.lod, err)
+				}
+				tiles[y*m.side+x] = channelPlane(tile, options.Channel)
+				return nil
+			})
+		}
+	}
+	if err := g.Wait(); err != nil {
+		return nil, err
+	}
+	return tiles, nil
+}
+
+func (m *mapRender) draw(tiles [][]int32, valueRange colormap.Range) *image.NRGBA {
+	mosaicSide := int(m.side) * constants.TileSize
+	mosaic := image.NewNRGBA(image.Rect(0, 0, mosaicSide, mosaicSide))
+	for i, tile := range tiles {
+		origin := image.Pt(i%int(m.side)*constants.TileSize, i/int(m.side)*constants.TileSize)
+		colormap.Draw(mosaic, origin, tile, constants.TileSize, m.cm, valueRange)
+	}
+
+	// Small repos don't fill a whole tile.
+	contentSide := max(m.options.Layout.GridSideLength()>>m.lod, 1)
+	cropSide := min(int(contentSide), mosaicSide)
+	img := scaleNearest(mosaic.SubImage(image.Rect(0, 0, cropSide, cropSide)).(*image.NRGBA), m.size)
+	if m.doublings == 0 {
+		return img
+	}
+	return placeInWorld(img, m.options.Size, m.doublings%2 == 1)
+}
+
+// RenderMap stitches together every tile of the map at the appropriate
+// LOD and scales the result to the requested size.
+func RenderMap(ctx context.Context, options MapRenderOptions) (*image.NRGBA, error) {
+	m, err := newMapRender(options)
+	if err != nil {
+		return nil, err
+	}
+
+	tiles, err := m.tiles(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	// Use one range for the whole map so tiles are comparable.
+	var values []int32
+	for _, tile := range tiles {
+		values = append(values, tile...)
+	}
+	valueRange, err := parseValueRange(options.Min, options.Max, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return m.draw(tiles, valueRange), nil
+}
+
+// MapValueRange returns the range of the non-zero values RenderMap
+// would draw ignoring Min and Max, so several maps can share one range.
+// It returns false if every value is zero.
+func MapValueRange(ctx context.Context, options MapRenderOptions) (colormap.Range, bool, error) {
+	m, err := newMapRender(options)
+	if err != nil {
+		return colormap.Range{}, false, err
+	}
+
+	tiles, err := m.tiles(ctx)
+	if err != nil {
+		return colormap.Range{}, false, err
+	}
+
+	r := colormap.Range{Min: math.MaxInt32, Max: math.MinInt32}
+	for _, tile := range tiles {
+		for _, v := range tile {
+			if v != 0 {
+				r.Min = min(r.Min, v)
+				r.Max = max(r.Max, v)
+			}
+		}
+	}
+	return r, r.Min <= r.Max, nil
+}
+
+// scaleNearest resizes a square image to size x size without blending
+// so individual lines stay crisp.
+func scaleNearest(src *image.NRGBA, size int) *image.NRGBA {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < size; y++ {
+		srcY := bounds.Min.Y + y*bounds.Dy()/size
+		for x := 0; x < size; x++ {
+			srcX := bounds.Min.X + x*bounds.Dx()/size
+			dst.SetNRGBA(x, y, src.NRGBAAt(srcX, srcY))
+		}
+	}
+	return dst
+}
+
+// placeInWorld copies src into the top left of a transparent image of
+// side size. Each doubling of the world transposes the part of the
+// Hilbert curve that is already laid out, so maps an odd number of
+// doublings smaller than the world are drawn transposed.
+func placeInWorld(src *image.NRGBA, size int, transpose bool) *image.NRGBA {
+	bounds := src.Bounds()
+	dst := image.NewNRGBA(image.Rect(0, 0, size, size))
+	for y := 0; y < min(bounds.Dy(), size); y++ {
+		for x := 0; x < min(bounds.Dx(), size); x++ {
+			c := src.NRGBAAt(bounds.Min.X+x, bounds.Min.Y+y)
+			if transpose {
+				dst.SetNRGBA(y, x, c)
+			} else {
+				dst.SetNRGBA(x, y, c)
+			}
+		}
+	}
+	return dst
+}
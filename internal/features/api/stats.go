@@ -0,0 +1,287 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/constants"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/index"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/julienschmidt/httprouter"
+	"golang.org/x/sync/errgroup"
+	"math"
+	"math/bits"
+	"net/http"
+	"strconv"
+)
+
+// Layer statistics are computed per tile and merged up the same
+// pyramid as macro-tiles so each level is cached and the root summarises
+// the whole commit. Unlike macro-tiles nothing is lost on the way up:
+// the histogram uses fixed power of two bins so it can be merged
+// without knowing the range in advance.
+
+// statsBinCount bins cover every int32: bin 32 is zero, bins above are
+// [2^(b-33), 2^(b-32)) and bins below mirror them for negative values.
+const statsBinCount = 64
+
+const statsZeroBin = 32
+
+func statsBin(v int32) int {
+	switch {
+	case v > 0:
+		return statsZeroBin + bits.Len32(uint32(v))
+	case v < 0:
+		return max(statsZeroBin-bits.Len64(uint64(-int64(v))), 0)
+	default:
+		return statsZeroBin
+	}
+}
+
+// statsBinBounds returns the range of values [lo, hi] in bin.
+func statsBinBounds(bin int) (int64, int64) {
+	switch {
+	case bin > statsZeroBin:
+		return int64(1) << (bin - statsZeroBin - 1), int64(1)<<(bin-statsZeroBin) - 1
+	case bin < statsZeroBin:
+		return -(int64(1) << (statsZeroBin - bin)) + 1, -(int64(1) << (statsZeroBin - bin - 1))
+	default:
+		return 0, 0
+	}
+}
+
+// tileStats is the mergeable summary of the pixels in a tile.
+type tileStats struct {
+	Count     int64
+	Min       int32
+	Max       int32
+	Sum       int64
+	Histogram []int64
+}
+
+func newTileStats() tileStats {
+	return tileStats{
+		Min:       math.MaxInt32,
+		Max:       math.MinInt32,
+		Histogram: make([]int64, statsBinCount),
+	}
+}
+
+func (s *tileStats) add(v int32) {
+	s.Count++
+	s.Min = min(s.Min, v)
+	s.Max = max(s.Max, v)
+	s.Sum += int64(v)
+	s.Histogram[statsBin(v)]++
+}
+
+func (s *tileStats) merge(other tileStats) {
+	if other.Count == 0 {
+		return
+	}
+	s.Count += other.Count
+	s.Min = min(s.Min, other.Min)
+	s.Max = max(s.Max, other.Max)
+	s.Sum += other.Sum
+	for i, count := range other.Histogram {
+		s.Histogram[i] += count
+	}
+}
+
+type HistogramBin struct {
+	Min   int64 `json:"min"`
+	Max   int64 `json:"max"`
+	Count int64 `json:"count"`
+}
+
+type LayerStats struct {
+	Count int64   `json:"count"`
+	Min   int32   `json:"min"`
+	Max   int32   `json:"max"`
+	Mean  float64 `json:"mean"`
+	// Histogram lists the non-empty power of two bins in order.
+	Histogram []HistogramBin `json:"histogram"`
+}
+
+func (s tileStats) toLayerStats() LayerStats {
+	result := LayerStats{
+		Count:     s.Count,
+		Histogram: []HistogramBin{},
+	}
+	if s.Count == 0 {
+		return result
+	}
+
+	result.Min = s.Min
+	result.Max = s.Max
+	result.Mean = float64(s.Sum) / float64(s.Count)
+	for bin, count := range s.Histogram {
+		if count == 0 {
+			continue
+		}
+		lo, hi := statsBinBounds(bin)
+		result.Histogram = append(result.Histogram, HistogramBin{Min: lo, Max: hi, Count: count})
+	}
+	return result
+}
+
+type statsRequest struct {
+	computationId string
+	repoName      string
+	commit        plumbing.Hash
+	params        core.TileParams
+	channel       int
+	nonZero       bool
+	layout        utils.TileLayout
+	// aggs has an entry for every channel. They are unused at LOD 0.
+	aggs []AggregationType
+}
+
+// tileStatsAt summarises every line drawn in a tile. Pixels past the
+// last line are not counted. With nonZero, zero values aren't either.
+func tileStatsAt(ctx context.Context, req statsRequest, lod int64, x int64, y int64) (tileStats, error) {
+	if utils.IsBlankTile(req.layout, lod, x, y) {
+		return newTileStats(), nil
+	}
+
+	key := core.GenerateCacheKey("tileStats", req.computationId, req.repoName, req.commit.String(), strconv.FormatInt(lod, 10), strconv.FormatInt(x, 10), strconv.FormatInt(y, 10), req.params.Key(), strconv.Itoa(req.channel), strconv.FormatBool(req.nonZero))
+	return core.GetOrCompute(key, func() (tileStats, error) {
+		stats := newTileStats()
+
+		if lod == 0 {
+			tile, err := getTile(ctx, req.computationId, req.repoName, req.commit, 0, x, y, req.aggs, req.params)
+			if err != nil {
+				return stats, err
+			}
+			for i, v := range channelPlane(tile, req.channel) {
+				pixelX := x*constants.TileSize + int64(i%constants.TileSize)
+				pixelY := y*constants.TileSize + int64(i/constants.TileSize)
+				if utils.IsEmptyPixel(req.layout, 0, pixelX, pixelY) || (req.nonZero && v == 0) {
+					continue
+				}
+				stats.add(v)
+			}
+			return stats, nil
+		}
+
+		children := make([]tileStats, 4)
+		g, gctx := errgroup.WithContext(ctx)
+		for i := range children {
+			i := i // capture loop variable
+			g.Go(func() error {
+				childX, childY := x*2+int64(i%2), y*2+int64(i/2)
+				child, err := tileStatsAt(gctx, req, lod-1, childX, childY)
+				if err != nil {
+					return fmt.Errorf("stats for tile (%d, %d) at LOD %d: %w", childX, childY, lod-1, err)
+				}
+				children[i] = child
+				return nil
+			})
+		}
+		if err := g.Wait(); err != nil {
+			return stats, err
+		}
+
+		for _, child := range children {
+			stats.merge(child)
+		}
+		return stats, nil
+	})
+}
+
+// GetLayerStats summarises a channel of a layer across a whole commit.
+func GetLayerStats(ctx context.Context, computationId string, repoName string, commit plumbing.Hash, params core.TileParams, channel int, nonZero bool) (LayerStats, error) {
+	computation, found := core.GetTileComputation(computationId)
+	if !found {
+		return LayerStats{}, fmt.Errorf("computation %s not found", computationId)
+	}
+	if channel < 0 || channel >= computation.ChannelCount() {
+		return LayerStats{}, fmt.Errorf("channel must be less than %d", computation.ChannelCount())
+	}
+
+	isBlank, err := index.IsBlankTile(ctx, repoName, commit, 0, 0, 0)
+	if err != nil {
+		return LayerStats{}, err
+	}
+	if isBlank {
+		return newTileStats().toLayerStats(), nil
+	}
+
+	layout, err := index.GetLayoutForCommit(ctx, repoName, commit)
+	if err != nil {
+		return LayerStats{}, err
+	}
+
+	req := statsRequest{
+		computationId: computationId,
+		repoName:      repoName,
+		commit:        commit,
+		params:        params,
+		channel:       channel,
+		nonZero:       nonZero,
+		layout:        layout,
+		aggs:          make([]AggregationType, computation.ChannelCount()),
+	}
+	stats, err := tileStatsAt(ctx, req, layout.RootLod(), 0, 0)
+	if err != nil {
+		return LayerStats{}, err
+	}
+	return stats.toLayerStats(), nil
+}
+
+// LayerStatsHandler returns the statistics of a layer for a commit.
+// Pass ?nonzero=1 to leave out zero values and ?channel= to pick the
+// channel of a multi-channel computation.
+func LayerStatsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repoId")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	rawCommit := ps.ByName("commit")
+	if !plumbing.IsHash(rawCommit) {
+		http.Error(w, fmt.Sprintf("Could not parse commit hash '%s'", rawCommit), http.StatusBadRequest)
+		return
+	}
+	commit := plumbing.NewHash(rawCommit)
+
+	tileComputationId := ps.ByName("tileComputationId")
+	computation, found := core.GetTileComputation(tileComputationId)
+	if !found {
+		http.Error(w, fmt.Sprintf("Computation '%s' unknown", tileComputationId), http.StatusNotFound)
+		return
+	}
+
+	params, err := core.ParseTileParams(computation.Params, r.URL.Query())
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	nonZero := r.URL.Query().Get("nonzero") == "1"
+
+	channel, err := parseChannel(r.URL.Query().Get("channel"), computation)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	etag := core.ImmutableETag("api.layer_stats", tileComputationId, repoName, commit.String(), params.Key(), strconv.Itoa(channel), strconv.FormatBool(nonZero))
+	if core.CheckNotModified(w, r, etag) {
+		return
+	}
+
+	stats, err := GetLayerStats(r.Context(), tileComputationId, repoName, commit, params, channel, nonZero)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	core.SetImmutableHeaders(w, etag)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(stats); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
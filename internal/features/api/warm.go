@@ -0,0 +1,122 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"golang.org/x/sync/errgroup"
+	"net/url"
+	"sync"
+)
+
+// WarmLayer is a tile computation along with the aggregation (and
+// params) the viewer requests it with. Macro-tiles are cached per
+// aggregation so these need to match for warming to help.
+type WarmLayer struct {
+	Computation string            `json:"computation"`
+	Agg         string            `json:"agg,omitempty"`
+	Params      map[string]string `json:"params,omitempty"`
+}
+
+type WarmProgress struct {
+	Layer string `json:"layer"`
+	Lod   int64  `json:"lod"`
+	Done  int    `json:"done"`
+	Total int    `json:"total"`
+}
+
+type warmLayer struct {
+	id     string
+	aggs   []AggregationType
+	params core.TileParams
+}
+
+func parseWarmLayer(layer WarmLayer) (warmLayer, error) {
+	computation, found := core.GetTileComputation(layer.Computation)
+	if !found {
+		return warmLayer{}, fmt.Errorf("computation '%s' unknown", layer.Computation)
+	}
+
+	aggs, err := parseAggregations(layer.Agg, computation)
+	if err != nil {
+		return warmLayer{}, err
+	}
+
+	query := url.Values{}
+	for name, value := range layer.Params {
+		query.Set(name, value)
+	}
+	params, err := core.ParseTileParams(computation.Params, query)
+	if err != nil {
+		return warmLayer{}, fmt.Errorf("%s: %w", layer.Computation, err)
+	}
+
+	return warmLayer{id: layer.Computation, aggs: aggs, params: params}, nil
+}
+
+// WarmTiles computes every tile of each layer from LOD 0 up to the root
+// so they are in the cache before anyone asks for them. Each LOD is
+// finished before the next so macro-tiles only read cached children.
+// At most parallelism tiles are computed at once. progress is called
+// after every tile.
+func WarmTiles(ctx context.Context, repoId string, commit plumbing.Hash, layout utils.TileLayout, layers []WarmLayer, parallelism int, progress func(WarmProgress)) error {
+	if parallelism <= 0 {
+		return fmt.Errorf("parallelism must be positive")
+	}
+
+	// Validate everything before starting any work.
+	parsed := make([]warmLayer, len(layers))
+	for i, layer := range layers {
+		p, err := parseWarmLayer(layer)
+		if err != nil {
+			return err
+		}
+		parsed[i] = p
+	}
+
+	root := layout.RootLod()
+	tilesPerLayer := 0
+	for lod := int64(0); lod <= root; lod++ {
+		side := layout.TilesPerSide(lod)
+		tilesPerLayer += int(side * side)
+	}
+
+	var mu sync.Mutex
+	status := WarmProgress{Total: tilesPerLayer * len(parsed)}
+
+	for _, layer := range parsed {
+		for lod := int64(0); lod <= root; lod++ {
+			side := layout.TilesPerSide(lod)
+
+			g, gctx := errgroup.WithContext(ctx)
+			g.SetLimit(parallelism)
+			for y := int64(0); y < side; y++ {
+				for x := int64(0); x < side; x++ {
+					lod, x, y := lod, x, y // capture loop variables
+					g.Go(func() error {
+						if _, err := getTile(gctx, layer.id, repoId, commit, lod, x, y, layer.aggs, layer.params); err != nil {
+							return fmt.Errorf("%s tile (%d, %d) at LOD %d: %w", layer.id, x, y, lod, err)
+						}
+
+						mu.Lock()
+						defer mu.Unlock()
+						status.Layer = layer.id
+						status.Lod = lod
+						status.Done++
+						if progress != nil {
+							progress(status)
+						}
+						return nil
+					})
+				}
+			}
+			if err := g.Wait(); err != nil {
+				return err
+			}
+		}
+	}
+
+	return nil
+}
@@ -6,4 +6,5 @@ import (
 	_ "github.com/chromy/mylar/internal/features/quadtree"
 	_ "github.com/chromy/mylar/internal/features/repo"
 	_ "github.com/chromy/mylar/internal/features/varz"
+	_ "github.com/chromy/mylar/internal/features/warm"
 )
@@ -0,0 +1,390 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/schemas"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/julienschmidt/httprouter"
+	"hash/fnv"
+	"net/http"
+	"slices"
+	"sort"
+	"strconv"
+	"time"
+)
+
+type BlameLine struct {
+	Author     string    `json:"author"`
+	AuthorName string    `json:"authorName"`
+	Hash       string    `json:"hash"`
+	Date       time.Time `json:"date"`
+	Text       string    `json:"text"`
+}
+
+type BlameResult struct {
+	// Path is the file which was blamed.
+	Path string `json:"path"`
+	// Paths lists every path in the commit with the same content,
+	// including Path. Their histories may differ from Path's.
+	Paths []string    `json:"paths"`
+	Lines []BlameLine `json:"lines"`
+}
+
+func blameForPath(ctx context.Context, repoId string, commit plumbing.Hash, path string) (BlameResult, error) {
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return BlameResult{}, err
+	}
+
+	ptr, err := repository.CommitObject(commit)
+	if err != nil {
+		return BlameResult{}, err
+	}
+
+	blameResult, err := git.Blame(ptr, path)
+	if err != nil {
+		return BlameResult{}, fmt.Errorf("blame %s failed: %w", path, err)
+	}
+
+	lines := make([]BlameLine, len(blameResult.Lines))
+	for i, line := range blameResult.Lines {
+		lines[i] = BlameLine{
+			Author:     line.Author,
+			AuthorName: line.AuthorName,
+			Hash:       line.Hash.String(),
+			Date:       line.Date,
+			Text:       line.Text,
+		}
+	}
+
+	return BlameResult{
+		Path:  blameResult.Path,
+		Paths: []string{blameResult.Path},
+		Lines: lines,
+	}, nil
+}
+
+// GetBlameForPath blames a single path at the given commit. Results
+// are cached per (commit, path) since the same blob can have a
+// different history at each path it appears.
+func GetBlameForPath(ctx context.Context, repoId string, commit plumbing.Hash, path string) (BlameResult, error) {
+	key := core.GenerateCacheKey("blameForPath", commit.String(), path)
+	return core.GetOrCompute(key, func() (BlameResult, error) {
+		return blameForPath(ctx, repoId, commit, path)
+	})
+}
+
+// blameEntry blames the path of entry and lists the other paths with
+// the same content.
+func blameEntry(ctx context.Context, repoId string, commit plumbing.Hash, index *Index, entry *IndexEntry) (BlameResult, error) {
+	result, err := GetBlameForPath(ctx, repoId, commit, entry.Path)
+	if err != nil {
+		return BlameResult{}, err
+	}
+	result.Paths = index.FindPathsByHash(entry.Hash)
+	return result, nil
+}
+
+// GetBlame blames a blob by hash. Only the hash is known so a blob at
+// several paths is blamed at the first of them; use the blame line
+// route to blame the path a particular line belongs to.
+var GetBlame = core.RegisterCommitComputation("blame", func(ctx context.Context, repoId string, commit plumbing.Hash, hash plumbing.Hash) (BlameResult, error) {
+	index, err := commitIndex(ctx, repoId, commit)
+	if err != nil {
+		return BlameResult{}, err
+	}
+
+	for i := range index.Entries {
+		if index.Entries[i].Hash == hash {
+			return blameEntry(ctx, repoId, commit, index, &index.Entries[i])
+		}
+	}
+	return BlameResult{}, fmt.Errorf("blob %s not found in commit %s", hash, commit)
+})
+
+func BlameLineHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repo")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish must be set", http.StatusBadRequest)
+		return
+	}
+
+	rawLine := ps.ByName("line")
+	if rawLine == "" {
+		http.Error(w, "line must be set", http.StatusBadRequest)
+		return
+	}
+	lineNumber, err := strconv.ParseInt(rawLine, 10, 64)
+	if err != nil {
+		http.Error(w, "line must be a number", http.StatusBadRequest)
+		return
+	}
+
+	repository, err := repo.ResolveRepo(r.Context(), repoName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, committish)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	index, err := commitIndex(r.Context(), repoName, commit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	entry := index.FindFileByLine(lineNumber)
+	if entry == nil {
+		http.Error(w, fmt.Sprintf("line %d not found in any file", lineNumber), http.StatusNotFound)
+		return
+	}
+
+	result, err := blameEntry(r.Context(), repoName, commit, index, entry)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	core.SetShortLivedHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(result); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+// changeDay is the day of a change counted from the Unix epoch so newer
+// changes have larger values: agg=max shows the newest code in a region
+// and agg=min the oldest. Changes on or before the first day are day
+// one so they remain distinguishable from empty pixels.
+func changeDay(changed time.Time) int32 {
+	return int32(max(changed.Unix()/int64((24*time.Hour).Seconds()), 1))
+}
+
+// GetTileAge sets each line to the day it was last changed (see
+// changeDay).
+var GetTileAge = core.RegisterTileComputation("age", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
+	return ExecuteLineTileComputation(ctx, repoId, commit, lod, x, y, func(entry *IndexEntry) (BlameResult, error) {
+		return GetBlameForPath(ctx, repoId, commit, entry.Path)
+	}, func(blame BlameResult, lineIdxInFile int64) int32 {
+		if lineIdxInFile < 0 || lineIdxInFile >= int64(len(blame.Lines)) {
+			return 0
+		}
+		return changeDay(blame.Lines[lineIdxInFile].Date)
+	})
+})
+
+// AuthorId hashes an author email onto the int32 used by the "author"
+// tile. Hashing keeps ids stable across commits and repos. Emails whose
+// hashes collide are moved to other ids by authorIds. Zero is reserved
+// for empty pixels.
+func AuthorId(email string) int32 {
+	h := fnv.New32a()
+	h.Write([]byte(email))
+	id := int32(h.Sum32())
+	if id == 0 {
+		return 1
+	}
+	return id
+}
+
+// authorIds gives each email its AuthorId, or the next free id if
+// another email already has it. Emails are taken in sorted order so
+// the ids only depend on the set of authors.
+func authorIds(emails []string) map[string]int32 {
+	sorted := slices.Clone(emails)
+	slices.Sort(sorted)
+
+	ids := make(map[string]int32, len(sorted))
+	taken := make(map[int32]bool, len(sorted))
+	for _, email := range sorted {
+		if _, found := ids[email]; found {
+			continue
+		}
+		id := AuthorId(email)
+		for taken[id] {
+			id++
+			if id == 0 {
+				id = 1
+			}
+		}
+		taken[id] = true
+		ids[email] = id
+	}
+	return ids
+}
+
+// GetTileAuthor sets each line to the id its author has in
+// GetAuthors.
+var GetTileAuthor = core.RegisterTileComputation("author", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
+	authors, err := GetAuthors(ctx, repoId, commit)
+	if err != nil {
+		return nil, err
+	}
+	ids := make(map[string]int32, len(authors))
+	for _, author := range authors {
+		ids[author.Email] = author.Id
+	}
+
+	return ExecuteLineTileComputation(ctx, repoId, commit, lod, x, y, func(entry *IndexEntry) (BlameResult, error) {
+		return GetBlameForPath(ctx, repoId, commit, entry.Path)
+	}, func(blame BlameResult, lineIdxInFile int64) int32 {
+		if lineIdxInFile < 0 || lineIdxInFile >= int64(len(blame.Lines)) {
+			return 0
+		}
+		email := blame.Lines[lineIdxInFile].Author
+		if id, found := ids[email]; found {
+			return id
+		}
+		return AuthorId(email)
+	})
+})
+
+type AuthorInfo struct {
+	Id    int32  `json:"id"`
+	Name  string `json:"name"`
+	Email string `json:"email"`
+}
+
+type AuthorsResponse struct {
+	Authors []AuthorInfo `json:"authors"`
+}
+
+func listAuthors(ctx context.Context, repoId string, commit plumbing.Hash) ([]AuthorInfo, error) {
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repository.Log(&git.LogOptions{From: commit})
+	if err != nil {
+		return nil, fmt.Errorf("log from %s: %w", commit, err)
+	}
+	defer iter.Close()
+
+	seen := make(map[string]bool)
+	authors := []AuthorInfo{}
+	err = iter.ForEach(func(c *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		email := c.Author.Email
+		if seen[email] {
+			return nil
+		}
+		seen[email] = true
+		authors = append(authors, AuthorInfo{
+			Name:  c.Author.Name,
+			Email: email,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	emails := make([]string, len(authors))
+	for i, author := range authors {
+		emails[i] = author.Email
+	}
+	ids := authorIds(emails)
+	for i := range authors {
+		authors[i].Id = ids[authors[i].Email]
+	}
+
+	sort.Slice(authors, func(i, j int) bool {
+		return authors[i].Id < authors[j].Id
+	})
+
+	return authors, nil
+}
+
+// GetAuthors lists every author reachable from commit along with the id
+// they are given in the "author" tile. Every author of a line has a
+// commit in the history so this walks the whole log once per commit,
+// which is far cheaper than blaming every file to find them.
+func GetAuthors(ctx context.Context, repoId string, commit plumbing.Hash) ([]AuthorInfo, error) {
+	key := core.GenerateCacheKey("authors", commit.String())
+	return core.GetOrCompute(key, func() ([]AuthorInfo, error) {
+		return listAuthors(ctx, repoId, commit)
+	})
+}
+
+func AuthorsHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repo")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish must be set", http.StatusBadRequest)
+		return
+	}
+
+	repository, err := repo.ResolveRepo(r.Context(), repoName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, committish)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	authors, err := GetAuthors(r.Context(), repoName, commit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := AuthorsResponse{
+		Authors: authors,
+	}
+
+	core.SetShortLivedHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func init() {
+	core.RegisterRoute(core.Route{
+		Id:      "index.authors",
+		Method:  http.MethodGet,
+		Path:    "/api/repo/:repo/:committish/authors",
+		Handler: AuthorsHandler,
+	})
+
+	core.RegisterRoute(core.Route{
+		Id:      "index.blame_line",
+		Method:  http.MethodGet,
+		Path:    "/api/repo/:repo/:committish/blame/line/:line",
+		Handler: BlameLineHandler,
+	})
+
+	schemas.Register("index.BlameLine", BlameLine{})
+	schemas.Register("index.BlameResult", BlameResult{})
+	schemas.Register("index.AuthorInfo", AuthorInfo{})
+	schemas.Register("index.AuthorsResponse", AuthorsResponse{})
+}
@@ -0,0 +1,135 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/go-git/go-git/v5"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+)
+
+func TestChangeDay(t *testing.T) {
+	tests := []struct {
+		name     string
+		changed  time.Time
+		expected int32
+	}{
+		{"Epoch", time.Unix(0, 0), 1},
+		{"Before the epoch", time.Unix(-1000000, 0), 1},
+		{"Second day", time.Unix(86400, 0), 1},
+		{"Later", time.Date(2024, 1, 2, 12, 0, 0, 0, time.UTC), 19724},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if result := changeDay(tt.changed); result != tt.expected {
+				t.Errorf("Expected %d, got %d", tt.expected, result)
+			}
+		})
+	}
+
+	older := changeDay(time.Date(2020, 6, 1, 0, 0, 0, 0, time.UTC))
+	newer := changeDay(time.Date(2021, 6, 1, 0, 0, 0, 0, time.UTC))
+	if newer <= older {
+		t.Errorf("Expected newer changes to have larger values, got %d <= %d", newer, older)
+	}
+}
+
+func TestAuthorIds(t *testing.T) {
+	// These two emails have the same FNV-32a hash.
+	first, second := "dev1141112@example.com", "dev718988@example.com"
+	if AuthorId(first) != AuthorId(second) {
+		t.Fatalf("Expected %s and %s to collide", first, second)
+	}
+
+	ids := authorIds([]string{second, "other@example.com", first, second})
+	if len(ids) != 3 {
+		t.Fatalf("Expected 3 ids, got %v", ids)
+	}
+	if ids[first] != AuthorId(first) {
+		t.Errorf("Expected the first email in order to keep its hash, got %d", ids[first])
+	}
+	if ids[second] != AuthorId(first)+1 {
+		t.Errorf("Expected the colliding email to take the next id, got %d", ids[second])
+	}
+	if ids["other@example.com"] != AuthorId("other@example.com") {
+		t.Errorf("Expected emails without collisions to keep their hash")
+	}
+
+	// Ids only depend on the set of emails.
+	if again := authorIds([]string{first, second, "other@example.com"}); !reflect.DeepEqual(again, ids) {
+		t.Errorf("Expected the same ids in any order, got %v and %v", ids, again)
+	}
+}
+
+func TestBlameLineHandler(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// The same content reaches a.go and b.go in different commits.
+	first := repotest.CommitFiles(t, repository, dir, "add b", time.Unix(1700000000, 0), map[string]string{"b.go": "same"})
+	second := repotest.CommitFiles(t, repository, dir, "add a", time.Unix(1700100000, 0), map[string]string{"a.go": "same"})
+
+	ctx := context.Background()
+	repoId := "test:blameline"
+	if err := repo.AddFromPath(ctx, repoId, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		line     int64
+		path     string
+		expected string
+	}{
+		{0, "a.go", second.String()},
+		{1, "b.go", first.String()},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.path, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			BlameLineHandler(w, httptest.NewRequest("GET", "/", nil), httprouter.Params{
+				{Key: "repo", Value: repoId},
+				{Key: "committish", Value: second.String()},
+				{Key: "line", Value: strconv.FormatInt(tt.line, 10)},
+			})
+			if w.Code != http.StatusOK {
+				t.Fatalf("Expected 200, got %d: %s", w.Code, w.Body.String())
+			}
+
+			var result BlameResult
+			if err := json.NewDecoder(w.Body).Decode(&result); err != nil {
+				t.Fatal(err)
+			}
+			if result.Path != tt.path {
+				t.Errorf("Expected path %s, got %s", tt.path, result.Path)
+			}
+			if !reflect.DeepEqual(result.Paths, []string{"a.go", "b.go"}) {
+				t.Errorf("Expected paths [a.go b.go], got %v", result.Paths)
+			}
+			if len(result.Lines) != 1 || result.Lines[0].Hash != tt.expected {
+				t.Errorf("Expected the line from %s, got %+v", tt.expected, result.Lines)
+			}
+		})
+	}
+
+	w := httptest.NewRecorder()
+	BlameLineHandler(w, httptest.NewRequest("GET", "/", nil), httprouter.Params{
+		{Key: "repo", Value: repoId},
+		{Key: "committish", Value: second.String()},
+		{Key: "line", Value: "2"},
+	})
+	if w.Code != http.StatusNotFound {
+		t.Errorf("Expected 404 past the last line, got %d", w.Code)
+	}
+}
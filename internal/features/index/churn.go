@@ -0,0 +1,121 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"strconv"
+)
+
+const defaultChurnDepth = 1000
+
+// countChurn walks the history of commit, newest first, counting how
+// many of the first depth commits changed each path. Each commit is
+// compared with its parent. Merges are skipped, and don't count towards
+// depth, since the commits they bring in are counted themselves.
+// Renames aren't followed: a renamed file starts again from one under
+// its new path and its earlier changes stay with the old path.
+func countChurn(ctx context.Context, repoId string, commit plumbing.Hash, depth int64) (map[string]int32, error) {
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return nil, err
+	}
+
+	iter, err := repository.Log(&git.LogOptions{From: commit})
+	if err != nil {
+		return nil, fmt.Errorf("log from %s: %w", commit, err)
+	}
+	defer iter.Close()
+
+	churn := make(map[string]int32)
+	var visited int64
+	err = iter.ForEach(func(c *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if c.NumParents() > 1 {
+			return nil
+		}
+
+		if visited >= depth {
+			return storer.ErrStop
+		}
+		visited++
+
+		tree, err := c.Tree()
+		if err != nil {
+			return err
+		}
+
+		var parentTree *object.Tree
+		if c.NumParents() == 1 {
+			parent, err := c.Parent(0)
+			if err != nil {
+				return err
+			}
+			if parentTree, err = parent.Tree(); err != nil {
+				return err
+			}
+		}
+
+		changes, err := object.DiffTreeContext(ctx, parentTree, tree)
+		if err != nil {
+			return fmt.Errorf("diff %s: %w", c.Hash, err)
+		}
+		for _, change := range changes {
+			path := change.To.Name
+			if path == "" {
+				path = change.From.Name
+			}
+			churn[path]++
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return churn, nil
+}
+
+// GetChurn counts the commits which changed each path within depth
+// commits of commit.
+func GetChurn(ctx context.Context, repoId string, commit plumbing.Hash, depth int64) (map[string]int32, error) {
+	key := core.GenerateCacheKey("churn", commit.String(), strconv.FormatInt(depth, 10))
+	return core.GetOrCompute(key, func() (map[string]int32, error) {
+		return countChurn(ctx, repoId, commit, depth)
+	})
+}
+
+var churnTileParams = []core.TileParam{
+	{Name: "depth", Type: core.TileParamInt, Default: strconv.Itoa(defaultChurnDepth)},
+}
+
+// GetTileChurn sets each line to the number of commits which changed
+// its file. Files untouched within depth commits are zero. See
+// countChurn for how merges and renames are counted.
+var GetTileChurn = core.RegisterTileComputation("churn", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
+	depth := params.Int("depth")
+	if depth <= 0 {
+		return nil, fmt.Errorf("depth must be positive")
+	}
+
+	churn, err := GetChurn(ctx, repoId, commit, depth)
+	if err != nil {
+		return nil, err
+	}
+
+	return ExecuteTileComputation(ctx, repoId, commit, lod, x, y, func(worldPos utils.WorldPosition, index *Index, layout utils.TileLayout) int32 {
+		linePos := utils.WorldToLine(worldPos, layout)
+		if entry := index.FindFileByLine(int64(linePos)); entry != nil {
+			return churn[entry.Path]
+		}
+		return 0
+	})
+}, core.WithTileParams(churnTileParams...))
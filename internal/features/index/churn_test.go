@@ -0,0 +1,76 @@
+package index
+
+import (
+	"context"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"testing"
+	"time"
+)
+
+func TestCountChurn(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	when := time.Unix(1700000000, 0)
+	first := repotest.CommitFiles(t, repository, dir, "change", when, map[string]string{"a.go": "1", "lib/b.go": "1"})
+	repotest.CommitFiles(t, repository, dir, "change", when, map[string]string{"a.go": "2"})
+	repotest.CommitFiles(t, repository, dir, "change", when, map[string]string{"a.go": "3", "lib/c.go": "1"})
+	head := repotest.CommitFiles(t, repository, dir, "change", when, map[string]string{"lib/b.go": "2"})
+
+	// Merges don't count towards the depth.
+	worktree, err := repository.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	signature := &object.Signature{Name: "Test", Email: "test@example.com", When: when}
+	merge, err := worktree.Commit("merge", &git.CommitOptions{
+		Author:            signature,
+		Committer:         signature,
+		Parents:           []plumbing.Hash{head, first},
+		AllowEmptyCommits: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := repo.AddFromPath(ctx, "test:churn", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		commit   plumbing.Hash
+		depth    int64
+		expected map[string]int32
+	}{
+		{"Whole history", head, 100, map[string]int32{"a.go": 3, "lib/b.go": 2, "lib/c.go": 1}},
+		{"Limited depth", head, 2, map[string]int32{"a.go": 1, "lib/b.go": 1, "lib/c.go": 1}},
+		{"Head only", head, 1, map[string]int32{"lib/b.go": 1}},
+		{"Merge skipped", merge, 1, map[string]int32{"lib/b.go": 1}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			churn, err := countChurn(ctx, "test:churn", tt.commit, tt.depth)
+			if err != nil {
+				t.Fatalf("countChurn failed: %v", err)
+			}
+			if len(churn) != len(tt.expected) {
+				t.Errorf("Expected %v, got %v", tt.expected, churn)
+			}
+			for path, count := range tt.expected {
+				if churn[path] != count {
+					t.Errorf("Expected %s to have churn %d, got %d", path, count, churn[path])
+				}
+			}
+		})
+	}
+}
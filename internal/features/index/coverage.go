@@ -0,0 +1,99 @@
+package index
+
+import (
+	"encoding/json"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/schemas"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+)
+
+type TileCoord struct {
+	X int64 `json:"x"`
+	Y int64 `json:"y"`
+}
+
+// TileCoverageResponse lists the tiles at a LOD which contain lines.
+// Any other tile is blank and need not be requested.
+type TileCoverageResponse struct {
+	Lod          int64       `json:"lod"`
+	TilesPerSide int64       `json:"tilesPerSide"`
+	Tiles        []TileCoord `json:"tiles"`
+}
+
+func CoverageHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repo")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish must be set", http.StatusBadRequest)
+		return
+	}
+
+	rawLod := ps.ByName("lod")
+	if rawLod == "" {
+		http.Error(w, "lod must be set", http.StatusBadRequest)
+		return
+	}
+	lod, err := strconv.ParseInt(rawLod, 10, 64)
+	if err != nil || lod < 0 || lod > 32 {
+		http.Error(w, "lod must be a number between 0 and 32", http.StatusBadRequest)
+		return
+	}
+
+	repository, err := repo.ResolveRepo(r.Context(), repoName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	treeHash, err := repo.ResolveCommittishToTreeish(repository, committish)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	index, err := GetIndex(r.Context(), repoName, treeHash)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := TileCoverageResponse{
+		Lod:          lod,
+		TilesPerSide: 1,
+		Tiles:        []TileCoord{},
+	}
+	if len(index.Entries) != 0 {
+		layout := index.ToTileLayout()
+		response.TilesPerSide = layout.TilesPerSide(lod)
+		for _, tile := range utils.CoveredTiles(layout, lod) {
+			response.Tiles = append(response.Tiles, TileCoord{X: tile.TileX, Y: tile.TileY})
+		}
+	}
+
+	core.SetShortLivedHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func init() {
+	core.RegisterRoute(core.Route{
+		Id:      "index.coverage",
+		Method:  http.MethodGet,
+		Path:    "/api/repo/:repo/:committish/coverage/:lod",
+		Handler: CoverageHandler,
+	})
+
+	schemas.Register("index.TileCoord", TileCoord{})
+	schemas.Register("index.TileCoverageResponse", TileCoverageResponse{})
+}
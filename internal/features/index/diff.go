@@ -0,0 +1,492 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/schemas"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/julienschmidt/httprouter"
+	"github.com/sergi/go-diff/diffmatchpatch"
+	"net/http"
+	"strings"
+	"sync"
+	"unicode/utf8"
+)
+
+// Values of the "diff" tile. Zero is an empty pixel.
+const (
+	DiffUnchanged int32 = 1
+	DiffModified  int32 = 2
+	DiffAdded     int32 = 3
+)
+
+// deletedLines is a run of lines of the old version of a file with no
+// counterpart in the new version.
+type deletedLines struct {
+	// BaseLine is the index of the first deleted line in the old file.
+	BaseLine int64
+	// Line is the index of the line in the new file the deleted lines
+	// came before.
+	Line  int64
+	Count int64
+}
+
+// lineDiff is the result of diffing two versions of a file.
+type lineDiff struct {
+	// Lines has the state of each line of the new version.
+	Lines   []int32
+	Deleted []deletedLines
+}
+
+// lineRune maps the i-th distinct line onto a rune for diffmatchpatch.
+// Surrogates are skipped since they don't survive the round trip
+// through a string.
+func lineRune(i int) (rune, bool) {
+	r := rune(i)
+	if r >= 0xD800 {
+		r += 0x800
+	}
+	return r, utf8.ValidRune(r)
+}
+
+// diffLines compares two versions of a file line by line. Within each
+// changed hunk inserted lines are paired up with deleted lines and
+// counted as modified. Any left over are added or deleted.
+func diffLines(base []string, target []string) lineDiff {
+	result := lineDiff{Lines: make([]int32, 0, len(target))}
+
+	ids := make(map[string]rune)
+	toRunes := func(lines []string) ([]rune, bool) {
+		runes := make([]rune, len(lines))
+		for i, line := range lines {
+			r, found := ids[line]
+			if !found {
+				var ok bool
+				if r, ok = lineRune(len(ids)); !ok {
+					return nil, false
+				}
+				ids[line] = r
+			}
+			runes[i] = r
+		}
+		return runes, true
+	}
+
+	baseRunes, baseOk := toRunes(base)
+	targetRunes, targetOk := toRunes(target)
+	var diffs []diffmatchpatch.Diff
+	if baseOk && targetOk {
+		diffs = diffmatchpatch.New().DiffMainRunes(baseRunes, targetRunes, false)
+	} else {
+		// Too many distinct lines to diff so treat it as a rewrite.
+		diffs = []diffmatchpatch.Diff{
+			{Type: diffmatchpatch.DiffDelete, Text: string(make([]rune, len(base)))},
+			{Type: diffmatchpatch.DiffInsert, Text: string(make([]rune, len(target)))},
+		}
+	}
+
+	var baseLine, deleted, inserted int64
+	flush := func() {
+		modified := min(deleted, inserted)
+		for i := int64(0); i < inserted; i++ {
+			if i < modified {
+				result.Lines = append(result.Lines, DiffModified)
+			} else {
+				result.Lines = append(result.Lines, DiffAdded)
+			}
+		}
+		if deleted > modified {
+			result.Deleted = append(result.Deleted, deletedLines{
+				BaseLine: baseLine - deleted + modified,
+				Line:     int64(len(result.Lines)),
+				Count:    deleted - modified,
+			})
+		}
+		deleted, inserted = 0, 0
+	}
+
+	for _, diff := range diffs {
+		n := int64(utf8.RuneCountInString(diff.Text))
+		switch diff.Type {
+		case diffmatchpatch.DiffEqual:
+			flush()
+			for i := int64(0); i < n; i++ {
+				result.Lines = append(result.Lines, DiffUnchanged)
+			}
+			baseLine += n
+		case diffmatchpatch.DiffDelete:
+			deleted += n
+			baseLine += n
+		case diffmatchpatch.DiffInsert:
+			inserted += n
+		}
+	}
+	flush()
+
+	return result
+}
+
+// GetLineDiff diffs two blobs. Results are cached by the pair of
+// hashes.
+func GetLineDiff(ctx context.Context, repoId string, base plumbing.Hash, target plumbing.Hash) (lineDiff, error) {
+	key := core.GenerateCacheKey("lineDiff", base.String(), target.String())
+	return core.GetOrCompute(key, func() (lineDiff, error) {
+		baseLines, err := repo.Lines(ctx, repoId, base)
+		if err != nil {
+			return lineDiff{}, err
+		}
+		targetLines, err := repo.Lines(ctx, repoId, target)
+		if err != nil {
+			return lineDiff{}, err
+		}
+		return diffLines(baseLines, targetLines), nil
+	})
+}
+
+// indexFiles is the tree diff side of an Index: the blob at each path
+// and the set of blobs.
+type indexFiles struct {
+	paths  map[string]plumbing.Hash
+	hashes map[plumbing.Hash]bool
+}
+
+func newIndexFiles(index *Index) indexFiles {
+	files := indexFiles{
+		paths:  make(map[string]plumbing.Hash, len(index.Entries)),
+		hashes: make(map[plumbing.Hash]bool, len(index.Entries)),
+	}
+	for _, entry := range index.Entries {
+		files.paths[entry.Path] = entry.Hash
+		files.hashes[entry.Hash] = true
+	}
+	return files
+}
+
+// maxIndexFilesCacheSize is the number of recent bases whose
+// indexFiles are kept.
+const maxIndexFilesCacheSize = 16
+
+var (
+	indexFilesMu    sync.RWMutex
+	indexFilesCache = make(map[string]indexFiles)
+	// indexFilesOrder lists the keys of indexFilesCache oldest first.
+	indexFilesOrder []string
+)
+
+// getIndexFiles returns the indexFiles of commit. They are kept in
+// memory so every tile diffed against the same base shares them. Only
+// the most recent bases are kept since each holds every path.
+func getIndexFiles(ctx context.Context, repoId string, commit plumbing.Hash) (indexFiles, error) {
+	tree, err := repo.CommitToTree(ctx, repoId, commit)
+	if err != nil {
+		return indexFiles{}, err
+	}
+	cacheKey := repoId + ":" + tree.String()
+
+	indexFilesMu.RLock()
+	if cached, found := indexFilesCache[cacheKey]; found {
+		indexFilesMu.RUnlock()
+		return cached, nil
+	}
+	indexFilesMu.RUnlock()
+
+	index, err := GetIndex(ctx, repoId, tree)
+	if err != nil {
+		return indexFiles{}, err
+	}
+	files := newIndexFiles(index)
+
+	indexFilesMu.Lock()
+	if _, found := indexFilesCache[cacheKey]; !found {
+		if len(indexFilesOrder) >= maxIndexFilesCacheSize {
+			delete(indexFilesCache, indexFilesOrder[0])
+			indexFilesOrder = indexFilesOrder[1:]
+		}
+		indexFilesOrder = append(indexFilesOrder, cacheKey)
+	}
+	indexFilesCache[cacheKey] = files
+	indexFilesMu.Unlock()
+
+	return files, nil
+}
+
+// entryDiff is the state of every line of a file in the target commit.
+type entryDiff struct {
+	// uniform is the state of every line unless diff is set.
+	uniform int32
+	diff    *lineDiff
+}
+
+func (d entryDiff) line(lineIdxInFile int64) int32 {
+	if d.diff == nil {
+		return d.uniform
+	}
+	if lineIdxInFile < 0 || lineIdxInFile >= int64(len(d.diff.Lines)) {
+		return 0
+	}
+	return d.diff.Lines[lineIdxInFile]
+}
+
+// diffEntry compares a file of the target commit with the file at the
+// same path in base. Content found elsewhere in base counts as moved
+// rather than added.
+func diffEntry(ctx context.Context, repoId string, base indexFiles, entry *IndexEntry) (entryDiff, error) {
+	baseHash, found := base.paths[entry.Path]
+	switch {
+	case found && baseHash == entry.Hash:
+		return entryDiff{uniform: DiffUnchanged}, nil
+	case !found && base.hashes[entry.Hash]:
+		return entryDiff{uniform: DiffUnchanged}, nil
+	case !found:
+		return entryDiff{uniform: DiffAdded}, nil
+	}
+
+	diff, err := GetLineDiff(ctx, repoId, baseHash, entry.Hash)
+	if err != nil {
+		return entryDiff{}, fmt.Errorf("diff %s: %w", entry.Path, err)
+	}
+	return entryDiff{diff: &diff}, nil
+}
+
+func commitIndex(ctx context.Context, repoId string, commit plumbing.Hash) (*Index, error) {
+	tree, err := repo.CommitToTree(ctx, repoId, commit)
+	if err != nil {
+		return nil, err
+	}
+	return GetIndex(ctx, repoId, tree)
+}
+
+var diffTileParams = []core.TileParam{
+	{Name: "base", Type: core.TileParamString, Required: true},
+}
+
+// diffBaseFromParams reads the base commit. It must be a full hash
+// since tiles are cached by their params.
+func diffBaseFromParams(params core.TileParams) (plumbing.Hash, error) {
+	base := params.String("base")
+	if !plumbing.IsHash(base) {
+		return plumbing.ZeroHash, fmt.Errorf("base must be a commit hash, got '%s'", base)
+	}
+	return plumbing.NewHash(base), nil
+}
+
+// GetTileDiff marks each line as DiffUnchanged, DiffModified or
+// DiffAdded relative to the base commit. Deleted lines have no pixels
+// so are listed by GetCommitDiff instead. The states are categories so
+// macro-tiles default to max, showing the biggest change in a region,
+// and can't be averaged.
+var GetTileDiff = core.RegisterTileComputation("diff", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
+	base, err := diffBaseFromParams(params)
+	if err != nil {
+		return nil, err
+	}
+
+	baseFiles, err := getIndexFiles(ctx, repoId, base)
+	if err != nil {
+		return nil, err
+	}
+
+	return ExecuteLineTileComputation(ctx, repoId, commit, lod, x, y, func(entry *IndexEntry) (entryDiff, error) {
+		return diffEntry(ctx, repoId, baseFiles, entry)
+	}, func(diff entryDiff, lineIdxInFile int64) int32 {
+		return diff.line(lineIdxInFile)
+	})
+}, core.WithTileParams(diffTileParams...), core.WithDefaultAgg("max"), core.WithCategorical())
+
+type DeletedRegion struct {
+	// Path is the file in the base commit the lines were deleted from.
+	Path string `json:"path"`
+	// BaseLine is the first deleted line within the base file.
+	BaseLine  int64 `json:"baseLine"`
+	LineCount int64 `json:"lineCount"`
+	// Line is the line of the target commit the region came before.
+	// For deleted files it is where the file would have been.
+	Line int64 `json:"line"`
+	// File is set if the whole file was deleted.
+	File bool `json:"file"`
+}
+
+// CommitDiff summarises the lines of a commit relative to a base. The
+// counts of unchanged, modified and added lines match the "diff" tile.
+type CommitDiff struct {
+	Base           string          `json:"base"`
+	Commit         string          `json:"commit"`
+	Unchanged      int64           `json:"unchanged"`
+	Modified       int64           `json:"modified"`
+	Added          int64           `json:"added"`
+	Deleted        int64           `json:"deleted"`
+	DeletedRegions []DeletedRegion `json:"deletedRegions"`
+}
+
+func computeCommitDiff(ctx context.Context, repoId string, base plumbing.Hash, commit plumbing.Hash) (CommitDiff, error) {
+	baseIndex, err := commitIndex(ctx, repoId, base)
+	if err != nil {
+		return CommitDiff{}, err
+	}
+	baseFiles, err := getIndexFiles(ctx, repoId, base)
+	if err != nil {
+		return CommitDiff{}, err
+	}
+	targetIndex, err := commitIndex(ctx, repoId, commit)
+	if err != nil {
+		return CommitDiff{}, err
+	}
+	targetFiles := newIndexFiles(targetIndex)
+
+	result := CommitDiff{
+		Base:           base.String(),
+		Commit:         commit.String(),
+		DeletedRegions: []DeletedRegion{},
+	}
+
+	for i := range targetIndex.Entries {
+		if err := ctx.Err(); err != nil {
+			return CommitDiff{}, err
+		}
+
+		entry := &targetIndex.Entries[i]
+		diff, err := diffEntry(ctx, repoId, baseFiles, entry)
+		if err != nil {
+			return CommitDiff{}, err
+		}
+
+		for line := int64(0); line < entry.LineCount; line++ {
+			switch diff.line(line) {
+			case DiffUnchanged:
+				result.Unchanged++
+			case DiffModified:
+				result.Modified++
+			case DiffAdded:
+				result.Added++
+			}
+		}
+
+		if diff.diff == nil {
+			continue
+		}
+		for _, deleted := range diff.diff.Deleted {
+			result.Deleted += deleted.Count
+			result.DeletedRegions = append(result.DeletedRegions, DeletedRegion{
+				Path:      entry.Path,
+				BaseLine:  deleted.BaseLine,
+				LineCount: deleted.Count,
+				Line:      entry.LineOffset + deleted.Line,
+			})
+		}
+	}
+
+	// Files missing from the target whose content isn't elsewhere in it.
+	for _, entry := range baseIndex.Entries {
+		if _, found := targetFiles.paths[entry.Path]; found || targetFiles.hashes[entry.Hash] || entry.LineCount == 0 {
+			continue
+		}
+		result.Deleted += entry.LineCount
+		result.DeletedRegions = append(result.DeletedRegions, DeletedRegion{
+			Path:      entry.Path,
+			LineCount: entry.LineCount,
+			Line:      insertionLine(targetIndex, entry.Path),
+			File:      true,
+		})
+	}
+
+	return result, nil
+}
+
+// treeLess reports if path a comes before path b in an index. Each
+// directory's entries are sorted by name (see GetTreeIndex) so paths
+// are compared a component at a time: "a/b" comes before "a.txt".
+func treeLess(a string, b string) bool {
+	as, bs := strings.Split(a, "/"), strings.Split(b, "/")
+	for i := 0; i < len(as) && i < len(bs); i++ {
+		if as[i] != bs[i] {
+			return as[i] < bs[i]
+		}
+	}
+	return len(as) < len(bs)
+}
+
+// insertionLine is the line a file at path would start at in index.
+func insertionLine(index *Index, path string) int64 {
+	var line int64
+	for _, entry := range index.Entries {
+		if treeLess(path, entry.Path) {
+			return entry.LineOffset
+		}
+		line = entry.LineOffset + entry.LineCount
+	}
+	return line
+}
+
+// GetCommitDiff compares commit with base. It reports the deleted
+// lines the "diff" tile can't show.
+func GetCommitDiff(ctx context.Context, repoId string, base plumbing.Hash, commit plumbing.Hash) (CommitDiff, error) {
+	key := core.GenerateCacheKey("commitDiff", base.String(), commit.String())
+	return core.GetOrCompute(key, func() (CommitDiff, error) {
+		return computeCommitDiff(ctx, repoId, base, commit)
+	})
+}
+
+func DiffHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repo")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish must be set", http.StatusBadRequest)
+		return
+	}
+
+	baseCommittish := ps.ByName("base")
+	if baseCommittish == "" {
+		http.Error(w, "base must be set", http.StatusBadRequest)
+		return
+	}
+
+	repository, err := repo.ResolveRepo(r.Context(), repoName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, committish)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	base, err := repo.ResolveCommittishToHash(repository, baseCommittish)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	diff, err := GetCommitDiff(r.Context(), repoName, base, commit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	core.SetShortLivedHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(diff); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func init() {
+	core.RegisterRoute(core.Route{
+		Id:      "index.diff",
+		Method:  http.MethodGet,
+		Path:    "/api/repo/:repo/:committish/diff/:base",
+		Handler: DiffHandler,
+	})
+
+	schemas.Register("index.DeletedRegion", DeletedRegion{})
+	schemas.Register("index.CommitDiff", CommitDiff{})
+}
@@ -0,0 +1,146 @@
+package index
+
+import (
+	"context"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/go-git/go-git/v5"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestDiffLines(t *testing.T) {
+	U, M, A := DiffUnchanged, DiffModified, DiffAdded
+
+	tests := []struct {
+		name    string
+		base    []string
+		target  []string
+		lines   []int32
+		deleted []deletedLines
+	}{
+		{"Identical", []string{"a", "b"}, []string{"a", "b"}, []int32{U, U}, nil},
+		{"Empty", []string{}, []string{}, []int32{}, nil},
+		{"New file", []string{}, []string{"a", "b"}, []int32{A, A}, nil},
+		{"Appended", []string{"a"}, []string{"a", "b"}, []int32{U, A}, nil},
+		{"Modified", []string{"a", "b", "c"}, []string{"a", "x", "c"}, []int32{U, M, U}, nil},
+		{"Grown hunk", []string{"a", "b", "c"}, []string{"a", "x", "y", "c"}, []int32{U, M, A, U}, nil},
+		{
+			"Deleted", []string{"a", "b", "c", "d"}, []string{"a", "d"}, []int32{U, U},
+			[]deletedLines{{BaseLine: 1, Line: 1, Count: 2}},
+		},
+		{
+			"Shrunk hunk", []string{"a", "b", "c", "d"}, []string{"a", "x", "d"}, []int32{U, M, U},
+			[]deletedLines{{BaseLine: 2, Line: 2, Count: 1}},
+		},
+		{
+			"Emptied", []string{"a", "b"}, []string{}, []int32{},
+			[]deletedLines{{BaseLine: 0, Line: 0, Count: 2}},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := diffLines(tt.base, tt.target)
+			if !reflect.DeepEqual(result.Lines, tt.lines) {
+				t.Errorf("Expected lines %v, got %v", tt.lines, result.Lines)
+			}
+			if !reflect.DeepEqual(result.Deleted, tt.deleted) {
+				t.Errorf("Expected deleted %v, got %v", tt.deleted, result.Deleted)
+			}
+		})
+	}
+}
+
+func TestLineRune(t *testing.T) {
+	for _, i := range []int{0, 0xD7FF, 0xD800, 0xF7FF, 0x10F7FF} {
+		r, ok := lineRune(i)
+		if !ok || r >= 0xD800 && r <= 0xDFFF {
+			t.Errorf("Expected a valid rune for %d, got %U", i, r)
+		}
+	}
+	if _, ok := lineRune(0x10F800); ok {
+		t.Error("Expected no rune past the last code point")
+	}
+}
+
+func TestInsertionLine(t *testing.T) {
+	index := &Index{Entries: []IndexEntry{
+		{Path: "a.go", LineOffset: 0, LineCount: 10},
+		{Path: "c.go", LineOffset: 10, LineCount: 5},
+	}}
+
+	tests := []struct {
+		path     string
+		expected int64
+	}{
+		{"0.go", 0},
+		{"b.go", 10},
+		{"d.go", 15},
+	}
+
+	for _, tt := range tests {
+		if line := insertionLine(index, tt.path); line != tt.expected {
+			t.Errorf("Expected %s at line %d, got %d", tt.path, tt.expected, line)
+		}
+	}
+
+	// Directories sort by name before files which extend their name.
+	nested := &Index{Entries: []IndexEntry{
+		{Path: "a/b", LineOffset: 0, LineCount: 10},
+		{Path: "a.txt", LineOffset: 10, LineCount: 5},
+		{Path: "b", LineOffset: 15, LineCount: 5},
+	}}
+	nestedTests := []struct {
+		path     string
+		expected int64
+	}{
+		{"a/a", 0},
+		{"a/c", 10},
+		{"a.md", 10},
+		{"a.zip", 15},
+		{"a", 0},
+		{"c/d", 20},
+	}
+	for _, tt := range nestedTests {
+		if line := insertionLine(nested, tt.path); line != tt.expected {
+			t.Errorf("Expected %s at line %d, got %d", tt.path, tt.expected, line)
+		}
+	}
+}
+
+func TestGetIndexFiles(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	commit := repotest.CommitFiles(t, repository, dir, "change", time.Unix(1700000000, 0), map[string]string{"a.go": "a\n", "lib/b.go": "b\n"})
+
+	ctx := context.Background()
+	repoId := "test:indexfiles"
+	if err := repo.AddFromPath(ctx, repoId, dir); err != nil {
+		t.Fatal(err)
+	}
+
+	files, err := getIndexFiles(ctx, repoId, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(files.paths) != 2 || len(files.hashes) != 2 {
+		t.Fatalf("Expected 2 paths and hashes, got %v and %v", files.paths, files.hashes)
+	}
+	if _, found := files.paths["lib/b.go"]; !found {
+		t.Errorf("Expected lib/b.go in %v", files.paths)
+	}
+
+	// Later tiles share the same maps rather than rebuilding them.
+	again, err := getIndexFiles(ctx, repoId, commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if reflect.ValueOf(again.paths).Pointer() != reflect.ValueOf(files.paths).Pointer() {
+		t.Errorf("Expected the cached indexFiles to be reused")
+	}
+}
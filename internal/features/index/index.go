@@ -9,7 +9,6 @@ import (
 	"github.com/chromy/mylar/internal/features/repo"
 	"github.com/chromy/mylar/internal/schemas"
 	"github.com/chromy/mylar/internal/utils"
-	"github.com/go-git/go-git/v5"
 	"github.com/go-git/go-git/v5/plumbing"
 	"github.com/go-git/go-git/v5/plumbing/filemode"
 	"github.com/go-git/go-git/v5/plumbing/object"
@@ -19,7 +18,6 @@ import (
 	"sort"
 	"strconv"
 	"sync"
-	"time"
 )
 
 type IndexEntry struct {
@@ -57,6 +55,19 @@ func (idx *Index) FindFileByLine(lineNumber int64) *IndexEntry {
 	return nil
 }
 
+// FindPathsByHash returns the paths of every entry with the given blob
+// hash in index order. Identical files share a hash so a single blob
+// may appear at many paths.
+func (idx *Index) FindPathsByHash(hash plumbing.Hash) []string {
+	var paths []string
+	for _, entry := range idx.Entries {
+		if entry.Hash == hash {
+			paths = append(paths, entry.Path)
+		}
+	}
+	return paths
+}
+
 func (idx *Index) ToTileLayout() utils.TileLayout {
 	lastEntry := idx.Entries[len(idx.Entries)-1]
 	lineCount := lastEntry.LineOffset + lastEntry.LineCount
@@ -64,9 +75,30 @@ func (idx *Index) ToTileLayout() utils.TileLayout {
 	return layout
 }
 
+// GetLayoutForCommit returns the tile layout of commit. It errors if
+// the commit has no files since there is nothing to lay out.
+func GetLayoutForCommit(ctx context.Context, repoId string, commit plumbing.Hash) (utils.TileLayout, error) {
+	tree, err := repo.CommitToTree(ctx, repoId, commit)
+	if err != nil {
+		return utils.TileLayout{}, err
+	}
+
+	idx, err := GetIndex(ctx, repoId, tree)
+	if err != nil {
+		return utils.TileLayout{}, err
+	}
+	if len(idx.Entries) == 0 {
+		return utils.TileLayout{}, fmt.Errorf("commit %s has no files", commit)
+	}
+
+	return idx.ToTileLayout(), nil
+}
+
 var mu sync.RWMutex
 var indexCache map[string]*Index = make(map[string]*Index)
 
+// IsBlankTile reports if a tile contains no lines so callers can skip
+// computing it.
 func IsBlankTile(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) (bool, error) {
 	tree, err := repo.CommitToTree(ctx, repoId, commit)
 	if err != nil {
@@ -78,18 +110,11 @@ func IsBlankTile(ctx context.Context, repoId string, commit plumbing.Hash, lod i
 		return false, err
 	}
 
-	layout := index.ToTileLayout()
-
-	tile := utils.TilePosition{
-		Lod:     lod,
-		TileX:   x,
-		TileY:   y,
-		OffsetX: 0,
-		OffsetY: 0,
+	if len(index.Entries) == 0 {
+		return true, nil
 	}
-	world := utils.TileToWorld(tile, layout)
-	line := utils.WorldToLine(world, layout)
-	return line >= layout.LineCount, nil
+
+	return utils.IsBlankTile(index.ToTileLayout(), lod, x, y), nil
 }
 
 var GetBlobIndex = core.RegisterBlobComputation("blobIndex", func(ctx context.Context, repoId string, hash plumbing.Hash) (Index, error) {
@@ -237,6 +262,7 @@ func IndexHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params)
 		return
 	}
 
+	core.SetShortLivedHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(index); err != nil {
 		http.Error(w, "failed to encode response", http.StatusInternalServerError)
@@ -261,55 +287,6 @@ var GetBlobLineLengths = core.RegisterBlobComputation("blobLineLengths", func(ct
 	return lengths, nil
 })
 
-type BlameLine struct {
-	Author     string    `json:"author"`
-	AuthorName string    `json:"authorName"`
-	Hash       string    `json:"hash"`
-	Date       time.Time `json:"date"`
-	Text       string    `json:"text"`
-}
-
-type BlameResult struct {
-	Path  string      `json:"path"`
-	Lines []BlameLine `json:"lines"`
-}
-
-var GetBlame = core.RegisterCommitComputation("blame", func(ctx context.Context, repoId string, commit plumbing.Hash, hash plumbing.Hash) (BlameResult, error) {
-	repository, err := repo.ResolveRepo(ctx, repoId)
-	if err != nil {
-		return BlameResult{}, err
-	}
-
-	ptr, err := repository.CommitObject(commit)
-	if err != nil {
-		return BlameResult{}, err
-	}
-
-	// TODO
-	path := "README.md"
-
-	blameResult, err := git.Blame(ptr, path)
-	if err != nil {
-		return BlameResult{}, fmt.Errorf("blame failed: %w", err)
-	}
-
-	lines := make([]BlameLine, len(blameResult.Lines))
-	for i, line := range blameResult.Lines {
-		lines[i] = BlameLine{
-			Author:     line.Author,
-			AuthorName: line.AuthorName,
-			Hash:       line.Hash.String(),
-			Date:       line.Date,
-			Text:       line.Text,
-		}
-	}
-
-	return BlameResult{
-		Path:  blameResult.Path,
-		Lines: lines,
-	}, nil
-})
-
 func ExecuteTileComputation(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, pixelFunc func(worldPos utils.WorldPosition, index *Index, layout utils.TileLayout) int32) ([]int32, error) {
 	if lod != 0 {
 		return make([]int32, constants.TileSize*constants.TileSize), nil
@@ -358,11 +335,51 @@ func ExecuteTileComputation(ctx context.Context, repoId string, commit plumbing.
 	return tile, nil
 }
 
+// ExecuteLineTileComputation is ExecuteTileComputation for layers
+// which need per-file data (e.g. blame) to compute each line. fileFunc
+// is called at most once per path in the tile and its result is passed
+// to lineFunc along with the line's index within the file.
+func ExecuteLineTileComputation[T any](ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, fileFunc func(entry *IndexEntry) (T, error), lineFunc func(data T, lineIdxInFile int64) int32) ([]int32, error) {
+	files := make(map[string]T)
+	var fileErr error
+
+	tile, err := ExecuteTileComputation(ctx, repoId, commit, lod, x, y, func(worldPos utils.WorldPosition, index *Index, layout utils.TileLayout) int32 {
+		if fileErr != nil {
+			return 0
+		}
+
+		linePos := utils.WorldToLine(worldPos, layout)
+		entry := index.FindFileByLine(int64(linePos))
+		if entry == nil {
+			return 0
+		}
+
+		data, found := files[entry.Path]
+		if !found {
+			data, fileErr = fileFunc(entry)
+			if fileErr != nil {
+				return 0
+			}
+			files[entry.Path] = data
+		}
+
+		return lineFunc(data, int64(linePos)-entry.LineOffset)
+	})
+	if err != nil {
+		return nil, err
+	}
+	if fileErr != nil {
+		return nil, fileErr
+	}
+
+	return tile, nil
+}
+
 //type Shader interface {
 //	Pixel(world *utils.WorldPosition, index *Index) int32
 //}
 
-var GetTileLineOffset = core.RegisterTileComputation("offset", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error) {
+var GetTileLineOffset = core.RegisterTileComputation("offset", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
 	return ExecuteTileComputation(ctx, repoId, commit, lod, x, y, func(worldPos utils.WorldPosition, index *Index, layout utils.TileLayout) int32 {
 		linePos := utils.WorldToLine(worldPos, layout)
 		if entry := index.FindFileByLine(int64(linePos)); entry != nil {
@@ -372,7 +389,7 @@ var GetTileLineOffset = core.RegisterTileComputation("offset", func(ctx context.
 	})
 })
 
-//var GetTileLineLength = core.RegisterTileComputation("length", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error) {
+//var GetTileLineLength = core.RegisterTileComputation("length", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
 //	return ExecuteTileComputation(ctx, repoId, commit, lod, x, y, func(worldPos utils.WorldPosition, index *Index, layout *utils.TileLayout) int32 {
 //		linePos := utils.WorldToLine(worldPos, *layout)
 //		if entry := index.FindFileByLine(int64(linePos)); entry != nil {
@@ -389,7 +406,7 @@ var GetTileLineOffset = core.RegisterTileComputation("offset", func(ctx context.
 //	})
 //})
 
-var GetTileLineLength = core.RegisterTileComputation("length", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error) {
+var GetTileLineLength = core.RegisterTileComputation("length", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
 	if lod != 0 {
 		return make([]int32, constants.TileSize*constants.TileSize), nil
 	}
@@ -457,7 +474,7 @@ var GetTileLineLength = core.RegisterTileComputation("length", func(ctx context.
 	return tile, nil
 })
 
-var GetTileLineIndent = core.RegisterTileComputation("indent", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error) {
+var GetTileLineIndent = core.RegisterTileComputation("indent", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
 	if lod != 0 {
 		return make([]int32, constants.TileSize*constants.TileSize), nil
 	}
@@ -525,7 +542,7 @@ var GetTileLineIndent = core.RegisterTileComputation("indent", func(ctx context.
 	return tile, nil
 })
 
-var GetTileFileHash = core.RegisterTileComputation("fileHash", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error) {
+var GetTileFileHash = core.RegisterTileComputation("fileHash", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
 	return ExecuteTileComputation(ctx, repoId, commit, lod, x, y, func(worldPos utils.WorldPosition, index *Index, layout utils.TileLayout) int32 {
 		linePos := utils.WorldToLine(worldPos, layout)
 		if entry := index.FindFileByLine(int64(linePos)); entry != nil {
@@ -535,7 +552,7 @@ var GetTileFileHash = core.RegisterTileComputation("fileHash", func(ctx context.
 	})
 })
 
-var GetTileFileExtension = core.RegisterTileComputation("fileExtension", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64) ([]int32, error) {
+var GetTileFileExtension = core.RegisterTileComputation("fileExtension", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
 	return ExecuteTileComputation(ctx, repoId, commit, lod, x, y, func(worldPos utils.WorldPosition, index *Index, layout utils.TileLayout) int32 {
 		linePos := utils.WorldToLine(worldPos, layout)
 		if entry := index.FindFileByLine(int64(linePos)); entry != nil {
@@ -554,6 +571,25 @@ var GetTileFileExtension = core.RegisterTileComputation("fileExtension", func(ct
 	})
 })
 
+// GetTileOverview combines the layers usually shown together so the
+// viewer can fetch them in one request. Each channel is the tile of the
+// computation of the same name.
+var GetTileOverview = core.RegisterMultiChannelTileComputation("overview", []core.TileChannel{
+	{Name: "fileHash", Agg: "mode"},
+	{Name: "length", Agg: "mean"},
+	{Name: "indent", Agg: "max"},
+}, func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
+	var tile []int32
+	for _, channel := range []core.TileFunc{GetTileFileHash, GetTileLineLength, GetTileLineIndent} {
+		plane, err := channel(ctx, repoId, commit, lod, x, y, params)
+		if err != nil {
+			return nil, err
+		}
+		tile = append(tile, plane...)
+	}
+	return tile, nil
+})
+
 type FileByLineResponse struct {
 	Entry         IndexEntry          `json:"entry"`
 	Content       string              `json:"content"`
@@ -629,6 +665,7 @@ func FileByLineHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Par
 		TilePosition:  tilePos,
 	}
 
+	core.SetShortLivedHeaders(w)
 	w.Header().Set("Content-Type", "application/json")
 	if err := json.NewEncoder(w).Encode(response); err != nil {
 		http.Error(w, "failed to encode response", http.StatusInternalServerError)
@@ -599,3 +599,41 @@ func TestFindFileByLineWithZeroLineCountEntries(t *testing.T) {
 		})
 	}
 }
+
+func TestFindPathsByHash(t *testing.T) {
+	hash1 := plumbing.NewHash("1111111111111111111111111111111111111111")
+	hash2 := plumbing.NewHash("2222222222222222222222222222222222222222")
+	hash3 := plumbing.NewHash("3333333333333333333333333333333333333333")
+
+	index := Index{
+		Entries: []IndexEntry{
+			{Path: "LICENSE", LineOffset: 0, LineCount: 3, Hash: hash1},
+			{Path: "README.md", LineOffset: 3, LineCount: 2, Hash: hash2},
+			{Path: "vendor/LICENSE", LineOffset: 5, LineCount: 3, Hash: hash1},
+		},
+	}
+
+	tests := []struct {
+		name     string
+		hash     plumbing.Hash
+		expected []string
+	}{
+		{"Single path", hash2, []string{"README.md"}},
+		{"Duplicated blob", hash1, []string{"LICENSE", "vendor/LICENSE"}},
+		{"Missing blob", hash3, nil},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := index.FindPathsByHash(tt.hash)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, result)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("Expected path %s at %d, got %s", tt.expected[i], i, result[i])
+				}
+			}
+		})
+	}
+}
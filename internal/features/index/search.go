@@ -0,0 +1,306 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/schemas"
+	"github.com/chromy/mylar/internal/utils"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+)
+
+const defaultSearchLimit = 1000
+
+type SearchQuery struct {
+	Pattern string
+	Regex   bool
+}
+
+// Matcher returns a function reporting whether a single line matches
+// the query.
+func (q SearchQuery) Matcher() (func(line string) bool, error) {
+	if q.Pattern == "" {
+		return nil, fmt.Errorf("empty search pattern")
+	}
+
+	if !q.Regex {
+		pattern := q.Pattern
+		return func(line string) bool {
+			return strings.Contains(line, pattern)
+		}, nil
+	}
+
+	re, err := regexp.Compile(q.Pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regex %q: %w", q.Pattern, err)
+	}
+	return re.MatchString, nil
+}
+
+// MatchingLines returns the indexes (within the blob) of every line of
+// the blob which matches.
+func MatchingLines(ctx context.Context, repoId string, hash plumbing.Hash, matcher func(line string) bool) ([]int64, error) {
+	lines, err := repo.Lines(ctx, repoId, hash)
+	if err != nil {
+		return nil, err
+	}
+
+	var matches []int64
+	for i, line := range lines {
+		if matcher(line) {
+			matches = append(matches, int64(i))
+		}
+	}
+	return matches, nil
+}
+
+// GetMatchingLines is MatchingLines cached per (blob, query).
+func GetMatchingLines(ctx context.Context, repoId string, hash plumbing.Hash, query SearchQuery) ([]int64, error) {
+	key := core.GenerateCacheKey("matchingLines", hash.String(), query.Pattern, strconv.FormatBool(query.Regex))
+	return core.GetOrCompute(key, func() ([]int64, error) {
+		matcher, err := query.Matcher()
+		if err != nil {
+			return nil, err
+		}
+		return MatchingLines(ctx, repoId, hash, matcher)
+	})
+}
+
+// searchQueryFromParams reads the query from the "q" and "regex" tile
+// params mirroring the search endpoint.
+func searchQueryFromParams(params core.TileParams) SearchQuery {
+	return SearchQuery{
+		Pattern: params.String("q"),
+		Regex:   params.Bool("regex"),
+	}
+}
+
+var searchTileParams = []core.TileParam{
+	{Name: "q", Type: core.TileParamString, Required: true},
+	{Name: "regex", Type: core.TileParamBool, Default: "false"},
+}
+
+// GetTileSearch sets each line matching the query to 1. Use with
+// agg=sum to count matches per macro-tile.
+var GetTileSearch = core.RegisterTileComputation("search", func(ctx context.Context, repoId string, commit plumbing.Hash, lod int64, x int64, y int64, params core.TileParams) ([]int32, error) {
+	query := searchQueryFromParams(params)
+	if _, err := query.Matcher(); err != nil {
+		return nil, err
+	}
+
+	return ExecuteLineTileComputation(ctx, repoId, commit, lod, x, y, func(entry *IndexEntry) (map[int64]bool, error) {
+		lineIdxs, err := GetMatchingLines(ctx, repoId, entry.Hash, query)
+		if err != nil {
+			return nil, err
+		}
+
+		matched := make(map[int64]bool, len(lineIdxs))
+		for _, lineIdx := range lineIdxs {
+			matched[lineIdx] = true
+		}
+		return matched, nil
+	}, func(matched map[int64]bool, lineIdxInFile int64) int32 {
+		if matched[lineIdxInFile] {
+			return 1
+		}
+		return 0
+	})
+}, core.WithTileParams(searchTileParams...))
+
+type SearchMatch struct {
+	Path          string              `json:"path"`
+	Line          int64               `json:"line"`
+	LineOffset    int64               `json:"lineOffset"`
+	Text          string              `json:"text"`
+	WorldPosition utils.WorldPosition `json:"worldPosition"`
+	TilePosition  utils.TilePosition  `json:"tilePosition"`
+}
+
+type SearchResponse struct {
+	Query     string        `json:"query"`
+	Regex     bool          `json:"regex"`
+	Matches   []SearchMatch `json:"matches"`
+	Truncated bool          `json:"truncated"`
+}
+
+// Search runs query against every file in tree returning at most
+// limit matches in line order. Only blobs which the trigram index
+// reports as candidates are read. The second result reports if the
+// matches were truncated.
+func Search(ctx context.Context, repoId string, tree plumbing.Hash, query SearchQuery, limit int) ([]SearchMatch, bool, error) {
+	if _, err := query.Matcher(); err != nil {
+		return nil, false, err
+	}
+
+	index, err := GetIndex(ctx, repoId, tree)
+	if err != nil {
+		return nil, false, err
+	}
+
+	candidates, err := SearchCandidates(ctx, repoId, tree, query)
+	if err != nil {
+		return nil, false, err
+	}
+
+	matches := []SearchMatch{}
+	if len(index.Entries) == 0 {
+		return matches, false, nil
+	}
+
+	layout := index.ToTileLayout()
+	blobMatches := make(map[plumbing.Hash][]int64)
+
+	for _, entry := range index.Entries {
+		if err := ctx.Err(); err != nil {
+			return nil, false, err
+		}
+
+		if entry.LineCount == 0 {
+			continue
+		}
+
+		if candidates != nil && !candidates[entry.Hash] {
+			continue
+		}
+
+		lineIdxs, found := blobMatches[entry.Hash]
+		if !found {
+			lineIdxs, err = GetMatchingLines(ctx, repoId, entry.Hash, query)
+			if err != nil {
+				return nil, false, fmt.Errorf("searching %s: %w", entry.Path, err)
+			}
+			blobMatches[entry.Hash] = lineIdxs
+		}
+
+		if len(lineIdxs) == 0 {
+			continue
+		}
+
+		lines, err := repo.Lines(ctx, repoId, entry.Hash)
+		if err != nil {
+			return nil, false, err
+		}
+
+		for _, lineIdx := range lineIdxs {
+			if len(matches) >= limit {
+				return matches, true, nil
+			}
+
+			linePos := utils.LinePosition(entry.LineOffset + lineIdx)
+			worldPos := utils.LineToWorld(linePos, layout)
+			matches = append(matches, SearchMatch{
+				Path:          entry.Path,
+				Line:          int64(linePos),
+				LineOffset:    lineIdx,
+				Text:          lines[lineIdx],
+				WorldPosition: worldPos,
+				TilePosition:  utils.WorldToTile(worldPos, layout),
+			})
+		}
+	}
+
+	return matches, false, nil
+}
+
+// searchQueryFromRequest reads the query from the "q" and "regex"
+// query params. regex is parsed like the bool tile param so both
+// endpoints accept the same values.
+func searchQueryFromRequest(r *http.Request) (SearchQuery, error) {
+	query := SearchQuery{Pattern: r.URL.Query().Get("q")}
+	if query.Pattern == "" {
+		return query, fmt.Errorf("q must be set")
+	}
+	if rawRegex := r.URL.Query().Get("regex"); rawRegex != "" {
+		regex, err := strconv.ParseBool(rawRegex)
+		if err != nil {
+			return query, fmt.Errorf("regex must be a bool, got '%s'", rawRegex)
+		}
+		query.Regex = regex
+	}
+	return query, nil
+}
+
+func SearchHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repo")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish must be set", http.StatusBadRequest)
+		return
+	}
+
+	query, err := searchQueryFromRequest(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	limit := defaultSearchLimit
+	if rawLimit := r.URL.Query().Get("limit"); rawLimit != "" {
+		parsed, err := strconv.Atoi(rawLimit)
+		if err != nil || parsed <= 0 {
+			http.Error(w, "limit must be a positive number", http.StatusBadRequest)
+			return
+		}
+		limit = parsed
+	}
+
+	if _, err := query.Matcher(); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repository, err := repo.ResolveRepo(r.Context(), repoName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	treeHash, err := repo.ResolveCommittishToTreeish(repository, committish)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	matches, truncated, err := Search(r.Context(), repoName, treeHash, query, limit)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	response := SearchResponse{
+		Query:     query.Pattern,
+		Regex:     query.Regex,
+		Matches:   matches,
+		Truncated: truncated,
+	}
+
+	core.SetShortLivedHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func init() {
+	core.RegisterRoute(core.Route{
+		Id:      "index.search",
+		Method:  http.MethodGet,
+		Path:    "/api/repo/:repo/:committish/search",
+		Handler: SearchHandler,
+	})
+
+	schemas.Register("index.SearchMatch", SearchMatch{})
+	schemas.Register("index.SearchResponse", SearchResponse{})
+}
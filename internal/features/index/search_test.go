@@ -0,0 +1,81 @@
+package index
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestSearchQueryMatcher(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    SearchQuery
+		line     string
+		expected bool
+	}{
+		{"Literal match", SearchQuery{Pattern: "TODO"}, "// TODO: fix", true},
+		{"Literal no match", SearchQuery{Pattern: "TODO"}, "// todo: fix", false},
+		{"Literal treats regex chars literally", SearchQuery{Pattern: "a.b"}, "axb", false},
+		{"Literal with regex chars", SearchQuery{Pattern: "a.b"}, "a.b", true},
+		{"Regex match", SearchQuery{Pattern: "^func \\w+", Regex: true}, "func main() {", true},
+		{"Regex no match", SearchQuery{Pattern: "^func \\w+", Regex: true}, "  func()", false},
+		{"Regex case insensitive", SearchQuery{Pattern: "(?i)todo", Regex: true}, "// ToDo", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			matcher, err := tt.query.Matcher()
+			if err != nil {
+				t.Fatalf("Matcher failed: %v", err)
+			}
+			if result := matcher(tt.line); result != tt.expected {
+				t.Errorf("Expected %v matching %q against %q, got %v", tt.expected, tt.query.Pattern, tt.line, result)
+			}
+		})
+	}
+}
+
+func TestSearchQueryMatcherErrors(t *testing.T) {
+	if _, err := (SearchQuery{}).Matcher(); err == nil {
+		t.Error("Expected error for empty pattern")
+	}
+	if _, err := (SearchQuery{Pattern: "(", Regex: true}).Matcher(); err == nil {
+		t.Error("Expected error for invalid regex")
+	}
+	if _, err := (SearchQuery{Pattern: "("}).Matcher(); err != nil {
+		t.Errorf("Expected literal pattern to be valid, got %v", err)
+	}
+}
+
+func TestSearchQueryFromRequest(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected SearchQuery
+		err      bool
+	}{
+		{"?q=TODO", SearchQuery{Pattern: "TODO"}, false},
+		{"?q=TODO&regex=1", SearchQuery{Pattern: "TODO", Regex: true}, false},
+		{"?q=TODO&regex=true", SearchQuery{Pattern: "TODO", Regex: true}, false},
+		{"?q=TODO&regex=false", SearchQuery{Pattern: "TODO"}, false},
+		{"?q=TODO&regex=0", SearchQuery{Pattern: "TODO"}, false},
+		{"?q=TODO&regex=yes", SearchQuery{}, true},
+		{"?regex=1", SearchQuery{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			query, err := searchQueryFromRequest(httptest.NewRequest("GET", "/api/repo/r/HEAD/search"+tt.query, nil))
+			if tt.err {
+				if err == nil {
+					t.Errorf("Expected an error, got %+v", query)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if query != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, query)
+			}
+		})
+	}
+}
@@ -0,0 +1,356 @@
+package index
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/schemas"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"time"
+)
+
+const defaultTimelineSamples = 20
+
+const maxTimelineSamples = 200
+
+// TreeStats summarises the files of a tree.
+type TreeStats struct {
+	Lines int64 `json:"lines"`
+	Files int64 `json:"files"`
+	// Extensions maps each file extension (without the dot, "" for
+	// none) to its number of lines.
+	Extensions map[string]int64 `json:"extensions"`
+}
+
+func indexStats(index *Index) TreeStats {
+	stats := TreeStats{
+		Files:      int64(len(index.Entries)),
+		Extensions: make(map[string]int64),
+	}
+	for _, entry := range index.Entries {
+		ext := filepath.Ext(entry.Path)
+		if len(ext) > 1 {
+			ext = ext[1:]
+		}
+		stats.Lines += entry.LineCount
+		stats.Extensions[ext] += entry.LineCount
+	}
+	return stats
+}
+
+// GetTreeStats summarises a tree. The index it is derived from is
+// cached per subtree so consecutive commits only index what changed.
+func GetTreeStats(ctx context.Context, repoId string, tree plumbing.Hash) (TreeStats, error) {
+	key := core.GenerateCacheKey("treeStats", tree.String())
+	return core.GetOrCompute(key, func() (TreeStats, error) {
+		// Use the tree index directly rather than GetIndex which keeps
+		// every index it sees in memory.
+		index, err := GetTreeIndex(ctx, repoId, tree)
+		if err != nil {
+			return TreeStats{}, err
+		}
+		return indexStats(&index), nil
+	})
+}
+
+type TimelinePoint struct {
+	Commit string    `json:"commit"`
+	Date   time.Time `json:"date"`
+	// Tag is set when sampling tags.
+	Tag   string    `json:"tag,omitempty"`
+	Stats TreeStats `json:"stats"`
+}
+
+type TimelineResponse struct {
+	Points []TimelinePoint `json:"points"`
+}
+
+// sampleEvenly picks up to samples indexes spread evenly over [0, n)
+// always including the first and last.
+func sampleEvenly(n int, samples int) []int {
+	if n <= samples {
+		indexes := make([]int, n)
+		for i := range indexes {
+			indexes[i] = i
+		}
+		return indexes
+	}
+	if samples == 1 {
+		return []int{n - 1}
+	}
+
+	indexes := make([]int, samples)
+	for i := range indexes {
+		indexes[i] = i * (n - 1) / (samples - 1)
+	}
+	return indexes
+}
+
+// firstParentCommits lists the first-parent history of commit oldest
+// first.
+func firstParentCommits(ctx context.Context, repository *git.Repository, commit plumbing.Hash) ([]*object.Commit, error) {
+	var commits []*object.Commit
+	for {
+		if err := ctx.Err(); err != nil {
+			return nil, err
+		}
+
+		c, err := repository.CommitObject(commit)
+		if err != nil {
+			return nil, fmt.Errorf("commit %s: %w", commit, err)
+		}
+		commits = append(commits, c)
+
+		if c.NumParents() == 0 {
+			break
+		}
+		commit = c.ParentHashes[0]
+	}
+
+	for i, j := 0, len(commits)-1; i < j; i, j = i+1, j-1 {
+		commits[i], commits[j] = commits[j], commits[i]
+	}
+	return commits, nil
+}
+
+// timelineSample is a commit to summarise and the tag it was found by.
+type timelineSample struct {
+	tag    string
+	commit *object.Commit
+}
+
+// reachableFrom returns which of targets are in the history of commit.
+// Histories never change so the walk is cached per commit and set of
+// targets. It stops once every target has been seen.
+func reachableFrom(ctx context.Context, repository *git.Repository, commit plumbing.Hash, targets []plumbing.Hash) (map[plumbing.Hash]bool, error) {
+	sorted := make([]string, len(targets))
+	for i, target := range targets {
+		sorted[i] = target.String()
+	}
+	sort.Strings(sorted)
+
+	key := core.GenerateCacheKey(append([]string{"reachableFrom", commit.String()}, sorted...)...)
+	found, err := core.GetOrCompute(key, func() ([]plumbing.Hash, error) {
+		wanted := make(map[plumbing.Hash]bool, len(targets))
+		for _, target := range targets {
+			wanted[target] = true
+		}
+
+		iter, err := repository.Log(&git.LogOptions{From: commit})
+		if err != nil {
+			return nil, fmt.Errorf("log from %s: %w", commit, err)
+		}
+		defer iter.Close()
+
+		found := []plumbing.Hash{}
+		err = iter.ForEach(func(c *object.Commit) error {
+			if err := ctx.Err(); err != nil {
+				return err
+			}
+			if wanted[c.Hash] {
+				delete(wanted, c.Hash)
+				found = append(found, c.Hash)
+			}
+			if len(wanted) == 0 {
+				return storer.ErrStop
+			}
+			return nil
+		})
+		if err != nil {
+			return nil, err
+		}
+		return found, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	reachable := make(map[plumbing.Hash]bool, len(found))
+	for _, hash := range found {
+		reachable[hash] = true
+	}
+	return reachable, nil
+}
+
+// tagSamples lists the tags reachable from commit, oldest first.
+func tagSamples(ctx context.Context, repository *git.Repository, commit plumbing.Hash) ([]timelineSample, error) {
+	tagIter, err := repository.Tags()
+	if err != nil {
+		return nil, err
+	}
+
+	var samples []timelineSample
+	err = tagIter.ForEach(func(ref *plumbing.Reference) error {
+		// Annotated tags point at a tag object rather than the commit
+		var c *object.Commit
+		if tag, err := repository.TagObject(ref.Hash()); err == nil {
+			if c, err = tag.Commit(); err != nil {
+				// Tags of trees and blobs have no history to show
+				return nil
+			}
+		} else if c, err = repository.CommitObject(ref.Hash()); err != nil {
+			return nil
+		}
+
+		samples = append(samples, timelineSample{tag: ref.Name().Short(), commit: c})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if len(samples) == 0 {
+		return samples, nil
+	}
+
+	targets := make([]plumbing.Hash, len(samples))
+	for i, sample := range samples {
+		targets[i] = sample.commit.Hash
+	}
+	reachable, err := reachableFrom(ctx, repository, commit, targets)
+	if err != nil {
+		return nil, err
+	}
+
+	reachableSamples := samples[:0]
+	for _, sample := range samples {
+		if reachable[sample.commit.Hash] {
+			reachableSamples = append(reachableSamples, sample)
+		}
+	}
+	samples = reachableSamples
+
+	sort.SliceStable(samples, func(i, j int) bool {
+		return samples[i].commit.Committer.When.Before(samples[j].commit.Committer.When)
+	})
+	return samples, nil
+}
+
+// GetTimeline summarises the history of commit, oldest first. With
+// tags it summarises up to samples of the tags reachable from commit,
+// otherwise up to samples commits along the first-parent history.
+// Either way the samples are spread evenly and include the first and
+// last.
+func GetTimeline(ctx context.Context, repoId string, commit plumbing.Hash, samples int, tags bool) (TimelineResponse, error) {
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return TimelineResponse{}, err
+	}
+
+	var picked []timelineSample
+	if tags {
+		tagged, err := tagSamples(ctx, repository, commit)
+		if err != nil {
+			return TimelineResponse{}, err
+		}
+		for _, i := range sampleEvenly(len(tagged), samples) {
+			picked = append(picked, tagged[i])
+		}
+	} else {
+		history, err := firstParentCommits(ctx, repository, commit)
+		if err != nil {
+			return TimelineResponse{}, err
+		}
+		for _, i := range sampleEvenly(len(history), samples) {
+			picked = append(picked, timelineSample{commit: history[i]})
+		}
+	}
+
+	response := TimelineResponse{Points: make([]TimelinePoint, 0, len(picked))}
+	for _, sample := range picked {
+		stats, err := GetTreeStats(ctx, repoId, sample.commit.TreeHash)
+		if err != nil {
+			return TimelineResponse{}, fmt.Errorf("commit %s: %w", sample.commit.Hash, err)
+		}
+		response.Points = append(response.Points, TimelinePoint{
+			Commit: sample.commit.Hash.String(),
+			Date:   sample.commit.Committer.When,
+			Tag:    sample.tag,
+			Stats:  stats,
+		})
+	}
+	return response, nil
+}
+
+// TimelineHandler returns line counts over the history of committish.
+// Pass ?samples= to pick how many commits to sample or ?tags=true to
+// sample tags instead.
+func TimelineHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoName := ps.ByName("repo")
+	if repoName == "" {
+		http.Error(w, "repo must be set", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish must be set", http.StatusBadRequest)
+		return
+	}
+
+	samples := defaultTimelineSamples
+	if raw := r.URL.Query().Get("samples"); raw != "" {
+		var err error
+		samples, err = strconv.Atoi(raw)
+		if err != nil || samples < 1 || samples > maxTimelineSamples {
+			http.Error(w, fmt.Sprintf("samples must be a number between 1 and %d", maxTimelineSamples), http.StatusBadRequest)
+			return
+		}
+	}
+
+	var tags bool
+	if raw := r.URL.Query().Get("tags"); raw != "" {
+		var err error
+		tags, err = strconv.ParseBool(raw)
+		if err != nil {
+			http.Error(w, "tags must be a bool", http.StatusBadRequest)
+			return
+		}
+	}
+
+	repository, err := repo.ResolveRepo(r.Context(), repoName)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, committish)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	response, err := GetTimeline(r.Context(), repoName, commit, samples, tags)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	core.SetShortLivedHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func init() {
+	core.RegisterRoute(core.Route{
+		Id:      "index.timeline",
+		Method:  http.MethodGet,
+		Path:    "/api/repo/:repo/:committish/timeline",
+		Handler: TimelineHandler,
+	})
+
+	schemas.Register("index.TreeStats", TreeStats{})
+	schemas.Register("index.TimelinePoint", TimelinePoint{})
+	schemas.Register("index.TimelineResponse", TimelineResponse{})
+}
@@ -0,0 +1,112 @@
+package index
+
+import (
+	"context"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestSampleEvenly(t *testing.T) {
+	tests := []struct {
+		n        int
+		samples  int
+		expected []int
+	}{
+		{0, 5, []int{}},
+		{3, 5, []int{0, 1, 2}},
+		{10, 1, []int{9}},
+		{10, 2, []int{0, 9}},
+		{10, 4, []int{0, 3, 6, 9}},
+		{100, 3, []int{0, 49, 99}},
+	}
+
+	for _, tt := range tests {
+		if result := sampleEvenly(tt.n, tt.samples); !reflect.DeepEqual(result, tt.expected) {
+			t.Errorf("sampleEvenly(%d, %d): expected %v, got %v", tt.n, tt.samples, tt.expected, result)
+		}
+	}
+}
+
+func TestIndexStats(t *testing.T) {
+	index := &Index{Entries: []IndexEntry{
+		{Path: "Makefile", LineCount: 4},
+		{Path: "a.go", LineCount: 10},
+		{Path: "lib/b.go", LineCount: 5},
+		{Path: "lib/c.tar.gz", LineCount: 0},
+		{Path: "web/.eslintrc", LineCount: 2},
+	}}
+
+	stats := indexStats(index)
+	if stats.Lines != 21 || stats.Files != 5 {
+		t.Errorf("Expected 21 lines in 5 files, got %d in %d", stats.Lines, stats.Files)
+	}
+	expected := map[string]int64{"": 4, "go": 15, "gz": 0, "eslintrc": 2}
+	if !reflect.DeepEqual(stats.Extensions, expected) {
+		t.Errorf("Expected extensions %v, got %v", expected, stats.Extensions)
+	}
+}
+
+func TestGetTimeline(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	when := time.Unix(1700000000, 0)
+	first := repotest.CommitFiles(t, repository, dir, "change", when, map[string]string{"a.go": "1\n2"})
+	second := repotest.CommitFiles(t, repository, dir, "change", when, map[string]string{"b.md": "1"})
+	third := repotest.CommitFiles(t, repository, dir, "change", when, map[string]string{"a.go": "1\n2\n3"})
+
+	if _, err := repository.CreateTag("v1", first, nil); err != nil {
+		t.Fatal(err)
+	}
+	tagger := &object.Signature{Name: "Test", Email: "test@example.com", When: when}
+	if _, err := repository.CreateTag("v2", third, &git.CreateTagOptions{Tagger: tagger, Message: "v2"}); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	if err := repo.AddFromPath(ctx, "test:timeline", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		from    plumbing.Hash
+		samples int
+		tags    bool
+		commits []plumbing.Hash
+		tagName []string
+		lines   []int64
+	}{
+		{"Every commit", third, 10, false, []plumbing.Hash{first, second, third}, []string{"", "", ""}, []int64{2, 3, 4}},
+		{"Sampled", third, 2, false, []plumbing.Hash{first, third}, []string{"", ""}, []int64{2, 4}},
+		{"Tags", third, 10, true, []plumbing.Hash{first, third}, []string{"v1", "v2"}, []int64{2, 4}},
+		{"Sampled tags", third, 1, true, []plumbing.Hash{third}, []string{"v2"}, []int64{4}},
+		{"Unreachable tags", second, 10, true, []plumbing.Hash{first}, []string{"v1"}, []int64{2}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := GetTimeline(ctx, "test:timeline", tt.from, tt.samples, tt.tags)
+			if err != nil {
+				t.Fatalf("GetTimeline failed: %v", err)
+			}
+			if len(response.Points) != len(tt.commits) {
+				t.Fatalf("Expected %d points, got %+v", len(tt.commits), response.Points)
+			}
+			for i, point := range response.Points {
+				if point.Commit != tt.commits[i].String() || point.Tag != tt.tagName[i] || point.Stats.Lines != tt.lines[i] {
+					t.Errorf("Point %d: expected %s %q with %d lines, got %s %q with %d", i, tt.commits[i], tt.tagName[i], tt.lines[i], point.Commit, point.Tag, point.Stats.Lines)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,330 @@
+package index
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/filemode"
+	"regexp/syntax"
+	"sort"
+)
+
+// We narrow searches down to candidate blobs using trigrams. Each blob
+// records the set of (byte) trigrams in its content. Each tree merges
+// the sets of its children into posting lists from trigram to blob in
+// the same way GetTreeIndex merges child indexes. Since trees are
+// content-addressed unchanged subtrees are shared between commits.
+//
+// Trigrams found in more than maxTrigramPostings blobs barely narrow a
+// search so their posting lists are dropped. This bounds the size of
+// each tree's index, which keeps the root of large repos cacheable.
+const maxTrigramPostings = 4096
+
+// trigramsOf returns the sorted unique trigrams in s. A trigram is
+// three consecutive bytes packed into the low 24 bits of a uint32.
+func trigramsOf(s string) []uint32 {
+	if len(s) < 3 {
+		return []uint32{}
+	}
+
+	seen := make(map[uint32]struct{})
+	for i := 0; i+3 <= len(s); i++ {
+		t := uint32(s[i])<<16 | uint32(s[i+1])<<8 | uint32(s[i+2])
+		seen[t] = struct{}{}
+	}
+
+	trigrams := make([]uint32, 0, len(seen))
+	for t := range seen {
+		trigrams = append(trigrams, t)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		return trigrams[i] < trigrams[j]
+	})
+	return trigrams
+}
+
+// TrigramIndex maps each trigram to the (sorted) ids of the blobs
+// containing it. Ids index into Blobs. Common lists the trigrams whose
+// posting lists were dropped for being too long; any blob may contain
+// them.
+type TrigramIndex struct {
+	Blobs    []plumbing.Hash    `json:"blobs"`
+	Postings map[uint32][]int32 `json:"postings"`
+	Common   []uint32           `json:"common"`
+}
+
+type trigramIndexBuilder struct {
+	index   TrigramIndex
+	blobIds map[plumbing.Hash]int32
+	common  map[uint32]bool
+}
+
+func newTrigramIndexBuilder() *trigramIndexBuilder {
+	return &trigramIndexBuilder{
+		index: TrigramIndex{
+			Blobs:    []plumbing.Hash{},
+			Postings: make(map[uint32][]int32),
+		},
+		blobIds: make(map[plumbing.Hash]int32),
+		common:  make(map[uint32]bool),
+	}
+}
+
+// addBlobId returns a new id for hash or false if hash was already
+// added. Ids are allocated in increasing order which keeps posting
+// lists sorted as they are appended to.
+func (b *trigramIndexBuilder) addBlobId(hash plumbing.Hash) (int32, bool) {
+	if _, found := b.blobIds[hash]; found {
+		return 0, false
+	}
+	id := int32(len(b.index.Blobs))
+	b.index.Blobs = append(b.index.Blobs, hash)
+	b.blobIds[hash] = id
+	return id, true
+}
+
+// addPosting records that blob id contains t, dropping the posting
+// list of t once it grows too long.
+func (b *trigramIndexBuilder) addPosting(t uint32, id int32) {
+	if b.common[t] {
+		return
+	}
+	if len(b.index.Postings[t]) >= maxTrigramPostings {
+		b.markCommon(t)
+		return
+	}
+	b.index.Postings[t] = append(b.index.Postings[t], id)
+}
+
+func (b *trigramIndexBuilder) markCommon(t uint32) {
+	b.common[t] = true
+	delete(b.index.Postings, t)
+}
+
+func (b *trigramIndexBuilder) addBlob(hash plumbing.Hash, trigrams []uint32) {
+	id, added := b.addBlobId(hash)
+	if !added {
+		return
+	}
+	for _, t := range trigrams {
+		b.addPosting(t, id)
+	}
+}
+
+func (b *trigramIndexBuilder) addIndex(child TrigramIndex) {
+	remap := make([]int32, len(child.Blobs))
+	for i, hash := range child.Blobs {
+		id, added := b.addBlobId(hash)
+		if !added {
+			// Same content as a blob we already have so its
+			// trigrams are already present.
+			id = -1
+		}
+		remap[i] = id
+	}
+
+	// A trigram too common for the child is too common for us.
+	for _, t := range child.Common {
+		b.markCommon(t)
+	}
+
+	// Iterate in a fixed order so ids from the child stay sorted.
+	trigrams := make([]uint32, 0, len(child.Postings))
+	for t := range child.Postings {
+		trigrams = append(trigrams, t)
+	}
+	sort.Slice(trigrams, func(i, j int) bool {
+		return trigrams[i] < trigrams[j]
+	})
+
+	for _, t := range trigrams {
+		for _, childId := range child.Postings[t] {
+			if id := remap[childId]; id >= 0 {
+				b.addPosting(t, id)
+			}
+		}
+	}
+}
+
+func (b *trigramIndexBuilder) build() TrigramIndex {
+	b.index.Common = make([]uint32, 0, len(b.common))
+	for t := range b.common {
+		b.index.Common = append(b.index.Common, t)
+	}
+	sort.Slice(b.index.Common, func(i, j int) bool {
+		return b.index.Common[i] < b.index.Common[j]
+	})
+	return b.index
+}
+
+// Candidates returns the blobs which may contain every one of
+// trigrams. Common trigrams can't rule anything out so are skipped. A
+// nil result means every blob is a candidate.
+func (idx *TrigramIndex) Candidates(trigrams []uint32) map[plumbing.Hash]bool {
+	common := make(map[uint32]bool, len(idx.Common))
+	for _, t := range idx.Common {
+		common[t] = true
+	}
+
+	var ids []int32
+	selective := false
+	for _, t := range trigrams {
+		if common[t] {
+			continue
+		}
+		posting := idx.Postings[t]
+		if !selective {
+			ids = append([]int32{}, posting...)
+			selective = true
+		} else {
+			ids = intersectSorted(ids, posting)
+		}
+		if len(ids) == 0 {
+			break
+		}
+	}
+	if !selective {
+		return nil
+	}
+
+	candidates := make(map[plumbing.Hash]bool, len(ids))
+	for _, id := range ids {
+		candidates[idx.Blobs[id]] = true
+	}
+	return candidates
+}
+
+func intersectSorted(a []int32, b []int32) []int32 {
+	result := a[:0]
+	i, j := 0, 0
+	for i < len(a) && j < len(b) {
+		switch {
+		case a[i] < b[j]:
+			i++
+		case a[i] > b[j]:
+			j++
+		default:
+			result = append(result, a[i])
+			i++
+			j++
+		}
+	}
+	return result
+}
+
+// Trigrams returns trigrams which must be present in any line matching
+// the query. For regexes we only use literal strings which every match
+// has to contain so the result may be empty.
+func (q SearchQuery) Trigrams() []uint32 {
+	if !q.Regex {
+		return trigramsOf(q.Pattern)
+	}
+
+	re, err := syntax.Parse(q.Pattern, syntax.Perl)
+	if err != nil {
+		return []uint32{}
+	}
+
+	seen := make(map[uint32]bool)
+	trigrams := []uint32{}
+	for _, literal := range requiredLiterals(re.Simplify()) {
+		for _, t := range trigramsOf(literal) {
+			if !seen[t] {
+				seen[t] = true
+				trigrams = append(trigrams, t)
+			}
+		}
+	}
+	return trigrams
+}
+
+func requiredLiterals(re *syntax.Regexp) []string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		if re.Flags&syntax.FoldCase != 0 {
+			return nil
+		}
+		return []string{string(re.Rune)}
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiterals(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiterals(re.Sub[0])
+		}
+		return nil
+	case syntax.OpConcat:
+		var literals []string
+		for _, sub := range re.Sub {
+			literals = append(literals, requiredLiterals(sub)...)
+		}
+		return literals
+	default:
+		return nil
+	}
+}
+
+var GetBlobTrigrams = core.RegisterBlobComputation("blobTrigrams", func(ctx context.Context, repoId string, hash plumbing.Hash) ([]uint32, error) {
+	content, err := repo.Content(ctx, repoId, hash)
+	if err != nil {
+		return nil, err
+	}
+	return trigramsOf(content), nil
+})
+
+var GetTreeTrigramIndex = core.RegisterBlobComputation("treeTrigrams", func(ctx context.Context, repoId string, hash plumbing.Hash) (TrigramIndex, error) {
+	getTreeTrigramIndex, found := core.GetBlobComputation("treeTrigrams")
+	if !found {
+		return TrigramIndex{}, fmt.Errorf("treeTrigrams blob computation not found")
+	}
+
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return TrigramIndex{}, err
+	}
+
+	treeObj, err := repository.TreeObject(hash)
+	if err != nil {
+		return TrigramIndex{}, fmt.Errorf("getting tree object %s: %s", hash, err)
+	}
+
+	builder := newTrigramIndexBuilder()
+
+	for _, entry := range treeObj.Entries {
+		switch entry.Mode {
+		case filemode.Submodule:
+			continue
+		case filemode.Dir:
+			child, err := getTreeTrigramIndex.Execute(ctx, repoId, entry.Hash)
+			if err != nil {
+				return TrigramIndex{}, fmt.Errorf("getting child trigrams %s: %s", entry.Hash, err)
+			}
+			builder.addIndex(child.(TrigramIndex))
+		default:
+			trigrams, err := GetBlobTrigrams(ctx, repoId, entry.Hash)
+			if err != nil {
+				return TrigramIndex{}, fmt.Errorf("getting blob trigrams %s: %s", entry.Hash, err)
+			}
+			builder.addBlob(entry.Hash, trigrams)
+		}
+	}
+
+	return builder.build(), nil
+})
+
+// SearchCandidates returns the blobs under tree which could match the
+// query. A nil result means every blob must be searched.
+func SearchCandidates(ctx context.Context, repoId string, tree plumbing.Hash, query SearchQuery) (map[plumbing.Hash]bool, error) {
+	trigrams := query.Trigrams()
+	if len(trigrams) == 0 {
+		return nil, nil
+	}
+
+	trigramIndex, err := GetTreeTrigramIndex(ctx, repoId, tree)
+	if err != nil {
+		return nil, err
+	}
+
+	return trigramIndex.Candidates(trigrams), nil
+}
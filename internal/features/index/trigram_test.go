@@ -0,0 +1,251 @@
+package index
+
+import (
+	"context"
+	"encoding/binary"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"reflect"
+	"testing"
+	"time"
+)
+
+func trigram(s string) uint32 {
+	return uint32(s[0])<<16 | uint32(s[1])<<8 | uint32(s[2])
+}
+
+func TestTrigramsOf(t *testing.T) {
+	tests := []struct {
+		name     string
+		content  string
+		expected []uint32
+	}{
+		{"Empty", "", []uint32{}},
+		{"Too short", "ab", []uint32{}},
+		{"Single", "abc", []uint32{trigram("abc")}},
+		{"Sorted", "cbab", []uint32{trigram("bab"), trigram("cba")}},
+		{"Deduplicated", "aaaa", []uint32{trigram("aaa")}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := trigramsOf(tt.content)
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, result)
+			}
+			for i := range result {
+				if result[i] != tt.expected[i] {
+					t.Errorf("Expected %v, got %v", tt.expected, result)
+				}
+			}
+		})
+	}
+}
+
+func TestTrigramIndexCandidates(t *testing.T) {
+	a := plumbing.NewHash("aaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaaa")
+	b := plumbing.NewHash("bbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbbb")
+	c := plumbing.NewHash("cccccccccccccccccccccccccccccccccccccccc")
+
+	child := newTrigramIndexBuilder()
+	child.addBlob(b, trigramsOf("// TODO later"))
+	child.addBlob(c, trigramsOf("func main()"))
+
+	root := newTrigramIndexBuilder()
+	root.addBlob(a, trigramsOf("// TODO: fix"))
+	root.addIndex(child.build())
+	// The same content in another subtree is only listed once.
+	root.addIndex(child.build())
+	index := root.build()
+
+	if len(index.Blobs) != 3 {
+		t.Fatalf("Expected 3 blobs, got %v", index.Blobs)
+	}
+
+	tests := []struct {
+		name     string
+		query    string
+		expected []plumbing.Hash
+	}{
+		{"Both", "TODO", []plumbing.Hash{a, b}},
+		{"One", "TODO later", []plumbing.Hash{b}},
+		{"Child only", "main", []plumbing.Hash{c}},
+		{"None", "nothing", []plumbing.Hash{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates := index.Candidates(trigramsOf(tt.query))
+			if len(candidates) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, candidates)
+			}
+			for _, hash := range tt.expected {
+				if !candidates[hash] {
+					t.Errorf("Expected %s to be a candidate", hash)
+				}
+			}
+		})
+	}
+}
+
+func TestTrigramIndexCommon(t *testing.T) {
+	common := trigram("abc")
+	rare := trigram("xyz")
+
+	child := newTrigramIndexBuilder()
+	var hash plumbing.Hash
+	for i := 0; i <= maxTrigramPostings; i++ {
+		binary.BigEndian.PutUint32(hash[:], uint32(i))
+		trigrams := []uint32{common}
+		if i == 0 {
+			trigrams = append(trigrams, rare)
+		}
+		child.addBlob(hash, trigrams)
+	}
+	childIndex := child.build()
+	if !reflect.DeepEqual(childIndex.Common, []uint32{common}) {
+		t.Fatalf("Expected abc to be common, got %v", childIndex.Common)
+	}
+	if _, found := childIndex.Postings[common]; found {
+		t.Errorf("Expected the posting list of a common trigram to be dropped")
+	}
+
+	// Common trigrams stay common in parents.
+	root := newTrigramIndexBuilder()
+	root.addIndex(childIndex)
+	index := root.build()
+	if !reflect.DeepEqual(index.Common, []uint32{common}) {
+		t.Fatalf("Expected abc to stay common, got %v", index.Common)
+	}
+
+	if candidates := index.Candidates([]uint32{common}); candidates != nil {
+		t.Errorf("Expected a common trigram to match every blob, got %d candidates", len(candidates))
+	}
+	if candidates := index.Candidates([]uint32{common, rare}); len(candidates) != 1 {
+		t.Errorf("Expected the rare trigram to narrow the search, got %d candidates", len(candidates))
+	}
+}
+
+func TestSearchCandidates(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// near.go has every letter of TODO but not its trigrams so the
+	// prefilter has to exclude it. copy.go shares a blob with todo.go.
+	files := map[string]string{
+		"main.go":      "func main() {\n}",
+		"todo.go":      "// TODO: fix\nfunc fix() {}",
+		"copy.go":      "// TODO: fix\nfunc fix() {}",
+		"lib/later.go": "// TODO later\nfunc helper() {}",
+		"lib/near.go":  "// TO DO\n// DOT",
+	}
+	commit := repotest.CommitFiles(t, repository, dir, "change", time.Unix(1700000000, 0), files)
+
+	ctx := context.Background()
+	if err := repo.AddFromPath(ctx, "test:trigrams", dir); err != nil {
+		t.Fatal(err)
+	}
+	tree, err := repo.CommitToTree(ctx, "test:trigrams", commit)
+	if err != nil {
+		t.Fatal(err)
+	}
+	index, err := GetIndex(ctx, "test:trigrams", tree)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hashes := make(map[string]plumbing.Hash)
+	for _, entry := range index.Entries {
+		hashes[entry.Path] = entry.Hash
+	}
+
+	tests := []struct {
+		name       string
+		query      SearchQuery
+		candidates []string
+		matches    []string
+	}{
+		{"Literal", SearchQuery{Pattern: "TODO"}, []string{"todo.go", "lib/later.go"}, []string{"copy.go", "lib/later.go", "todo.go"}},
+		{"Regex literal", SearchQuery{Pattern: "func \\w+\\(\\)", Regex: true}, []string{"main.go", "todo.go", "lib/later.go"}, []string{"copy.go", "lib/later.go", "main.go", "todo.go"}},
+		{"No candidates", SearchQuery{Pattern: "nothing"}, []string{}, []string{}},
+		{"Every blob", SearchQuery{Pattern: "f|O", Regex: true}, nil, []string{"copy.go", "lib/later.go", "lib/near.go", "main.go", "todo.go"}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			candidates, err := SearchCandidates(ctx, "test:trigrams", tree, tt.query)
+			if err != nil {
+				t.Fatalf("SearchCandidates failed: %v", err)
+			}
+			if tt.candidates == nil {
+				if candidates != nil {
+					t.Errorf("Expected every blob to be a candidate, got %v", candidates)
+				}
+			} else {
+				if len(candidates) != len(tt.candidates) {
+					t.Errorf("Expected candidates %v, got %v", tt.candidates, candidates)
+				}
+				for _, path := range tt.candidates {
+					if !candidates[hashes[path]] {
+						t.Errorf("Expected %s to be a candidate", path)
+					}
+				}
+			}
+
+			matches, truncated, err := Search(ctx, "test:trigrams", tree, tt.query, 100)
+			if err != nil {
+				t.Fatalf("Search failed: %v", err)
+			}
+			if truncated {
+				t.Error("Expected matches not to be truncated")
+			}
+			paths := []string{}
+			for _, match := range matches {
+				if len(paths) == 0 || paths[len(paths)-1] != match.Path {
+					paths = append(paths, match.Path)
+				}
+			}
+			if !reflect.DeepEqual(paths, tt.matches) {
+				t.Errorf("Expected matches in %v, got %v", tt.matches, paths)
+			}
+		})
+	}
+}
+
+func TestSearchQueryTrigrams(t *testing.T) {
+	tests := []struct {
+		name     string
+		query    SearchQuery
+		expected []string
+	}{
+		{"Literal", SearchQuery{Pattern: "TODO"}, []string{"TOD", "ODO"}},
+		{"Short literal", SearchQuery{Pattern: "ab"}, []string{}},
+		{"Regex literal", SearchQuery{Pattern: "func \\w+", Regex: true}, []string{"fun", "unc", "nc "}},
+		{"Regex alternation", SearchQuery{Pattern: "abc|def", Regex: true}, []string{}},
+		{"Regex case insensitive", SearchQuery{Pattern: "(?i)todo", Regex: true}, []string{}},
+		{"Regex optional", SearchQuery{Pattern: "(abc)?xyz", Regex: true}, []string{"xyz"}},
+		{"Invalid regex", SearchQuery{Pattern: "(", Regex: true}, []string{}},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			result := tt.query.Trigrams()
+			if len(result) != len(tt.expected) {
+				t.Fatalf("Expected %v, got %v", tt.expected, result)
+			}
+			found := make(map[uint32]bool)
+			for _, t := range result {
+				found[t] = true
+			}
+			for _, s := range tt.expected {
+				if !found[trigram(s)] {
+					t.Errorf("Expected trigram %q", s)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,203 @@
+package repo
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/schemas"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"github.com/go-git/go-git/v5/plumbing/storer"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"strconv"
+	"strings"
+	"time"
+)
+
+const defaultLogLimit = 50
+
+const maxLogLimit = 500
+
+type LogEntry struct {
+	Hash string `json:"hash"`
+	// Author is the author's email as in index.BlameLine.
+	Author     string    `json:"author"`
+	AuthorName string    `json:"authorName"`
+	Date       time.Time `json:"date"`
+	Message    string    `json:"message"`
+	Parents    []string  `json:"parents"`
+}
+
+type LogResponse struct {
+	Commit  string     `json:"commit"`
+	Offset  int        `json:"offset"`
+	Commits []LogEntry `json:"commits"`
+	// HasMore is set if there are commits after this page.
+	HasMore bool `json:"hasMore"`
+}
+
+type LogOptions struct {
+	Offset int
+	Limit  int
+	// Path limits the log to commits changing the file or directory
+	// at Path.
+	Path string
+}
+
+// pathFilter matches path and everything inside it.
+func pathFilter(path string) func(string) bool {
+	path = strings.Trim(path, "/")
+	return func(changed string) bool {
+		return changed == path || strings.HasPrefix(changed, path+"/")
+	}
+}
+
+// GetLog lists the history of commit newest first, like git log.
+func GetLog(ctx context.Context, repoId string, commit plumbing.Hash, options LogOptions) (LogResponse, error) {
+	repository, err := ResolveRepo(ctx, repoId)
+	if err != nil {
+		return LogResponse{}, err
+	}
+
+	logOptions := &git.LogOptions{
+		From:  commit,
+		Order: git.LogOrderCommitterTime,
+	}
+	if options.Path != "" {
+		logOptions.PathFilter = pathFilter(options.Path)
+	}
+
+	iter, err := repository.Log(logOptions)
+	if err != nil {
+		return LogResponse{}, fmt.Errorf("log from %s: %w", commit, err)
+	}
+	defer iter.Close()
+
+	response := LogResponse{
+		Commit:  commit.String(),
+		Offset:  options.Offset,
+		Commits: []LogEntry{},
+	}
+
+	skipped := 0
+	err = iter.ForEach(func(c *object.Commit) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		if skipped < options.Offset {
+			skipped++
+			return nil
+		}
+		if len(response.Commits) == options.Limit {
+			response.HasMore = true
+			return storer.ErrStop
+		}
+
+		parents := make([]string, len(c.ParentHashes))
+		for i, parent := range c.ParentHashes {
+			parents[i] = parent.String()
+		}
+		response.Commits = append(response.Commits, LogEntry{
+			Hash:       c.Hash.String(),
+			Author:     c.Author.Email,
+			AuthorName: c.Author.Name,
+			Date:       c.Author.When,
+			Message:    c.Message,
+			Parents:    parents,
+		})
+		return nil
+	})
+	if err != nil {
+		return LogResponse{}, err
+	}
+
+	return response, nil
+}
+
+// parseLogOptions reads ?offset=, ?limit= and ?path=.
+func parseLogOptions(r *http.Request) (LogOptions, error) {
+	query := r.URL.Query()
+	options := LogOptions{
+		Limit: defaultLogLimit,
+		Path:  query.Get("path"),
+	}
+
+	if raw := query.Get("offset"); raw != "" {
+		offset, err := strconv.Atoi(raw)
+		if err != nil || offset < 0 {
+			return options, fmt.Errorf("offset must be a non-negative number")
+		}
+		options.Offset = offset
+	}
+
+	if raw := query.Get("limit"); raw != "" {
+		limit, err := strconv.Atoi(raw)
+		if err != nil || limit < 1 || limit > maxLogLimit {
+			return options, fmt.Errorf("limit must be a number between 1 and %d", maxLogLimit)
+		}
+		options.Limit = limit
+	}
+
+	return options, nil
+}
+
+// LogHandler lists commits reachable from committish a page at a
+// time. Pass ?path= to only list commits changing a file or directory.
+func LogHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoId := ps.ByName("repo")
+	if repoId == "" {
+		http.Error(w, "repo parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	options, err := parseLogOptions(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	repo, err := ResolveRepo(r.Context(), repoId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to resolve repo: %v", err), http.StatusNotFound)
+		return
+	}
+
+	commit, err := ResolveCommittishToHash(repo, committish)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to resolve committish: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	response, err := GetLog(r.Context(), repoId, commit, options)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list commits: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	core.SetShortLivedHeaders(w)
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
+func init() {
+	core.RegisterRoute(core.Route{
+		Id:      "repo.log",
+		Method:  http.MethodGet,
+		Path:    "/api/repo/:repo/:committish/log",
+		Handler: LogHandler,
+	})
+
+	schemas.Register("repo.LogEntry", LogEntry{})
+	schemas.Register("repo.LogResponse", LogResponse{})
+}
@@ -0,0 +1,112 @@
+package repo
+
+import (
+	"context"
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"net/http/httptest"
+	"testing"
+	"time"
+)
+
+func TestGetLog(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	first := repotest.CommitFiles(t, repository, dir, "first\n", start, map[string]string{"a.go": "1"})
+	second := repotest.CommitFiles(t, repository, dir, "second\n", start.Add(time.Hour), map[string]string{"lib/b.go": "1"})
+	third := repotest.CommitFiles(t, repository, dir, "third\n", start.Add(2*time.Hour), map[string]string{"a.go": "2"})
+
+	ctx := context.Background()
+	if err := AddFromPath(ctx, "test:log", dir); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		options  LogOptions
+		expected []plumbing.Hash
+		hasMore  bool
+	}{
+		{"Everything", LogOptions{Limit: 10}, []plumbing.Hash{third, second, first}, false},
+		{"First page", LogOptions{Limit: 2}, []plumbing.Hash{third, second}, true},
+		{"Second page", LogOptions{Offset: 2, Limit: 2}, []plumbing.Hash{first}, false},
+		{"Past the end", LogOptions{Offset: 5, Limit: 2}, []plumbing.Hash{}, false},
+		{"File", LogOptions{Limit: 10, Path: "a.go"}, []plumbing.Hash{third, first}, false},
+		{"Directory", LogOptions{Limit: 10, Path: "lib/"}, []plumbing.Hash{second}, false},
+		{"Prefix is not a directory", LogOptions{Limit: 10, Path: "li"}, []plumbing.Hash{}, false},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			response, err := GetLog(ctx, "test:log", third, tt.options)
+			if err != nil {
+				t.Fatalf("GetLog failed: %v", err)
+			}
+			if len(response.Commits) != len(tt.expected) {
+				t.Fatalf("Expected %d commits, got %v", len(tt.expected), response.Commits)
+			}
+			for i, hash := range tt.expected {
+				if response.Commits[i].Hash != hash.String() {
+					t.Errorf("Expected commit %d to be %s, got %s", i, hash, response.Commits[i].Hash)
+				}
+			}
+			if response.HasMore != tt.hasMore {
+				t.Errorf("Expected hasMore %v, got %v", tt.hasMore, response.HasMore)
+			}
+		})
+	}
+
+	response, err := GetLog(ctx, "test:log", third, LogOptions{Limit: 1, Offset: 1})
+	if err != nil {
+		t.Fatal(err)
+	}
+	entry := response.Commits[0]
+	if entry.Message != "second\n" || entry.Author != "test@example.com" || entry.AuthorName != "Test" {
+		t.Errorf("Unexpected entry %+v", entry)
+	}
+	if !entry.Date.Equal(start.Add(time.Hour)) {
+		t.Errorf("Expected date %v, got %v", start.Add(time.Hour), entry.Date)
+	}
+	if len(entry.Parents) != 1 || entry.Parents[0] != first.String() {
+		t.Errorf("Expected parent %s, got %v", first, entry.Parents)
+	}
+}
+
+func TestParseLogOptions(t *testing.T) {
+	tests := []struct {
+		query    string
+		expected LogOptions
+		wantErr  bool
+	}{
+		{"", LogOptions{Limit: defaultLogLimit}, false},
+		{"?offset=10&limit=5&path=lib", LogOptions{Offset: 10, Limit: 5, Path: "lib"}, false},
+		{"?offset=-1", LogOptions{}, true},
+		{"?limit=0", LogOptions{}, true},
+		{"?limit=100000", LogOptions{}, true},
+		{"?limit=ten", LogOptions{}, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.query, func(t *testing.T) {
+			options, err := parseLogOptions(httptest.NewRequest("GET", "/api/repo/r/HEAD/log"+tt.query, nil))
+			if tt.wantErr {
+				if err == nil {
+					t.Errorf("Expected error, got %+v", options)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatalf("parseLogOptions failed: %v", err)
+			}
+			if options != tt.expected {
+				t.Errorf("Expected %+v, got %+v", tt.expected, options)
+			}
+		})
+	}
+}
@@ -66,6 +66,18 @@ type TagListResponse struct {
 	Tags []TagInfo `json:"tags"`
 }
 
+type BranchInfo struct {
+	Name   string    `json:"name"`
+	Commit string    `json:"commit"`
+	Date   time.Time `json:"date"`
+	// Default is set for the branch HEAD points at.
+	Default bool `json:"default"`
+}
+
+type BranchListResponse struct {
+	Branches []BranchInfo `json:"branches"`
+}
+
 type AddFromPathOptions struct {
 	Name  string
 	Owner string
@@ -413,6 +425,74 @@ func TagListHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params
 	}
 }
 
+// ListBranches lists the local branches of repo (which for GitHub repos
+// mirror the remote's, see UpdateRepo) sorted by name.
+func ListBranches(repo *git.Repository) ([]BranchInfo, error) {
+	var defaultBranch plumbing.ReferenceName
+	if head, err := repo.Reference(plumbing.HEAD, false); err == nil && head.Type() == plumbing.SymbolicReference {
+		defaultBranch = head.Target()
+	}
+
+	branchIter, err := repo.Branches()
+	if err != nil {
+		return nil, err
+	}
+
+	branches := []BranchInfo{}
+	err = branchIter.ForEach(func(ref *plumbing.Reference) error {
+		commit, err := repo.CommitObject(ref.Hash())
+		if err != nil {
+			return fmt.Errorf("branch %s: %w", ref.Name().Short(), err)
+		}
+
+		branches = append(branches, BranchInfo{
+			Name:    ref.Name().Short(),
+			Commit:  ref.Hash().String(),
+			Date:    commit.Committer.When,
+			Default: ref.Name() == defaultBranch,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	sort.Slice(branches, func(i, j int) bool {
+		return branches[i].Name < branches[j].Name
+	})
+
+	return branches, nil
+}
+
+func BranchListHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	repoId := ps.ByName("repoId")
+	if repoId == "" {
+		http.Error(w, "repoId parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	repo, err := ResolveRepo(r.Context(), repoId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to resolve repo: %v", err), http.StatusNotFound)
+		return
+	}
+
+	branches, err := ListBranches(repo)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to list branches: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	response := BranchListResponse{
+		Branches: branches,
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(response); err != nil {
+		http.Error(w, "failed to encode response", http.StatusInternalServerError)
+	}
+}
+
 var IsBinary = core.RegisterBlobComputation("isBinary", func(ctx context.Context, repoId string, hash plumbing.Hash) (bool, error) {
 	repo, err := ResolveRepo(ctx, repoId)
 	if err != nil {
@@ -601,6 +681,13 @@ func init() {
 		Handler: TagListHandler,
 	})
 
+	core.RegisterRoute(core.Route{
+		Id:      "repo.branches",
+		Method:  http.MethodGet,
+		Path:    "/api/branches/:repoId",
+		Handler: BranchListHandler,
+	})
+
 	core.RegisterRoute(core.Route{
 		Id:      "repo.update",
 		Method:  http.MethodPost,
@@ -613,6 +700,8 @@ func init() {
 	schemas.Register("repo.ResolveCommittishResponse", ResolveCommittishResponse{})
 	schemas.Register("repo.TagInfo", TagInfo{})
 	schemas.Register("repo.TagListResponse", TagListResponse{})
+	schemas.Register("repo.BranchInfo", BranchInfo{})
+	schemas.Register("repo.BranchListResponse", BranchListResponse{})
 	schemas.Register("repo.TreeEntry", TreeEntry{})
 	schemas.Register("repo.TreeEntries", TreeEntries{})
 }
@@ -0,0 +1,43 @@
+package repo
+
+import (
+	"github.com/chromy/mylar/internal/features/repo/repotest"
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"testing"
+	"time"
+)
+
+func TestListBranches(t *testing.T) {
+	dir := t.TempDir()
+	repository, err := git.PlainInit(dir, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Unix(1700000000, 0)
+	first := repotest.CommitFiles(t, repository, dir, "first\n", start, map[string]string{"a.go": "1"})
+	second := repotest.CommitFiles(t, repository, dir, "second\n", start.Add(time.Hour), map[string]string{"a.go": "2"})
+	if err := repository.Storer.SetReference(plumbing.NewHashReference("refs/heads/feature", first)); err != nil {
+		t.Fatal(err)
+	}
+
+	branches, err := ListBranches(repository)
+	if err != nil {
+		t.Fatalf("ListBranches failed: %v", err)
+	}
+
+	expected := []BranchInfo{
+		{Name: "feature", Commit: first.String(), Date: start},
+		{Name: "master", Commit: second.String(), Date: start.Add(time.Hour), Default: true},
+	}
+	if len(branches) != len(expected) {
+		t.Fatalf("Expected %d branches, got %+v", len(expected), branches)
+	}
+	for i, branch := range branches {
+		want := expected[i]
+		if branch.Name != want.Name || branch.Commit != want.Commit || branch.Default != want.Default || !branch.Date.Equal(want.Date) {
+			t.Errorf("Expected %+v, got %+v", want, branch)
+		}
+	}
+}
@@ -0,0 +1,42 @@
+// Package repotest builds git repositories for tests.
+package repotest
+
+import (
+	"github.com/go-git/go-git/v5"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/go-git/go-git/v5/plumbing/object"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// CommitFiles writes files into the worktree at dir and commits them
+// with message authored and committed at when.
+func CommitFiles(t testing.TB, repository *git.Repository, dir string, message string, when time.Time, files map[string]string) plumbing.Hash {
+	t.Helper()
+
+	worktree, err := repository.Worktree()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		if _, err := worktree.Add(name); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	signature := &object.Signature{Name: "Test", Email: "test@example.com", When: when}
+	hash, err := worktree.Commit(message, &git.CommitOptions{Author: signature, Committer: signature})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hash
+}
@@ -0,0 +1,192 @@
+package warm
+
+import (
+	"context"
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"github.com/chromy/mylar/internal/core"
+	"github.com/chromy/mylar/internal/features/api"
+	"github.com/chromy/mylar/internal/features/index"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/schemas"
+	"github.com/go-git/go-git/v5/plumbing"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"os"
+	"strings"
+)
+
+const DefaultParallelism = 8
+
+// DefaultLayers are the layers offered by the viewer (js/layers.tsx)
+// with the aggregation it uses for each.
+var DefaultLayers = []api.WarmLayer{
+	{Computation: "length", Agg: "mean"},
+	{Computation: "indent", Agg: "max"},
+	{Computation: "offset", Agg: "mean"},
+	{Computation: "fileHash", Agg: "mode"},
+	{Computation: "fileExtension", Agg: "mode"},
+	{Computation: "age", Agg: "mean:nonzero"},
+	{Computation: "author", Agg: "mode:nonzero"},
+	{Computation: "churn", Agg: "max"},
+}
+
+// ParseLayers parses a comma separated list of computation[:agg].
+func ParseLayers(s string) ([]api.WarmLayer, error) {
+	var layers []api.WarmLayer
+	for _, item := range strings.Split(s, ",") {
+		item = strings.TrimSpace(item)
+		if item == "" {
+			continue
+		}
+		computation, agg, _ := strings.Cut(item, ":")
+		if computation == "" {
+			return nil, fmt.Errorf("invalid layer %q", item)
+		}
+		layers = append(layers, api.WarmLayer{Computation: computation, Agg: agg})
+	}
+	if len(layers) == 0 {
+		return nil, fmt.Errorf("no layers given")
+	}
+	return layers, nil
+}
+
+// Warm precomputes every tile of layers for commit.
+func Warm(ctx context.Context, repoId string, commit plumbing.Hash, layers []api.WarmLayer, parallelism int, progress func(api.WarmProgress)) error {
+	layout, err := index.GetLayoutForCommit(ctx, repoId, commit)
+	if err != nil {
+		return err
+	}
+	return api.WarmTiles(ctx, repoId, commit, layout, layers, parallelism, progress)
+}
+
+// ProgressPercent is the integer percentage complete, used to throttle
+// progress reports.
+func ProgressPercent(progress api.WarmProgress) int {
+	if progress.Total == 0 {
+		return 100
+	}
+	return progress.Done * 100 / progress.Total
+}
+
+// clampParallelism keeps requested parallelism within what the server
+// allows, defaulting to DefaultParallelism.
+func clampParallelism(parallelism int) int {
+	if parallelism <= 0 || parallelism > DefaultParallelism {
+		return DefaultParallelism
+	}
+	return parallelism
+}
+
+type WarmRequest struct {
+	Layers []api.WarmLayer `json:"layers"`
+	// Parallelism is capped at DefaultParallelism.
+	Parallelism int `json:"parallelism"`
+}
+
+type WarmStatus struct {
+	Commit   string           `json:"commit"`
+	Progress api.WarmProgress `json:"progress"`
+	Finished bool             `json:"finished"`
+	Error    string           `json:"error,omitempty"`
+}
+
+// authorized checks the bearer token against MYLAR_ADMIN_TOKEN. The
+// admin endpoints are closed unless a token is configured.
+func authorized(r *http.Request) bool {
+	token := os.Getenv("MYLAR_ADMIN_TOKEN")
+	if token == "" {
+		return false
+	}
+	given, found := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+	return found && subtle.ConstantTimeCompare([]byte(given), []byte(token)) == 1
+}
+
+// WarmHandler warms the cache for a commit streaming newline delimited
+// WarmStatus objects as it goes. The last line has finished set or an
+// error.
+func WarmHandler(w http.ResponseWriter, r *http.Request, ps httprouter.Params) {
+	if os.Getenv("MYLAR_ADMIN_TOKEN") == "" {
+		http.Error(w, "admin endpoints are disabled, set MYLAR_ADMIN_TOKEN to enable them", http.StatusForbidden)
+		return
+	}
+	if !authorized(r) {
+		http.Error(w, "unauthorized", http.StatusUnauthorized)
+		return
+	}
+
+	repoId := ps.ByName("repoId")
+	if repoId == "" {
+		http.Error(w, "repoId parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	committish := ps.ByName("committish")
+	if committish == "" {
+		http.Error(w, "committish parameter is required", http.StatusBadRequest)
+		return
+	}
+
+	request := WarmRequest{}
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(r.Body).Decode(&request); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %s", err), http.StatusBadRequest)
+			return
+		}
+	}
+	if len(request.Layers) == 0 {
+		request.Layers = DefaultLayers
+	}
+	request.Parallelism = clampParallelism(request.Parallelism)
+
+	repository, err := repo.ResolveRepo(r.Context(), repoId)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to resolve repo: %v", err), http.StatusNotFound)
+		return
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, committish)
+	if err != nil {
+		http.Error(w, fmt.Sprintf("failed to resolve committish: %v", err), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	encoder := json.NewEncoder(w)
+	flusher, _ := w.(http.Flusher)
+	write := func(status WarmStatus) {
+		encoder.Encode(status)
+		if flusher != nil {
+			flusher.Flush()
+		}
+	}
+
+	status := WarmStatus{Commit: commit.String()}
+	lastPercent := -1
+	err = Warm(r.Context(), repoId, commit, request.Layers, request.Parallelism, func(progress api.WarmProgress) {
+		status.Progress = progress
+		if percent := ProgressPercent(progress); percent != lastPercent {
+			lastPercent = percent
+			write(status)
+		}
+	})
+	if err != nil {
+		status.Error = err.Error()
+	} else {
+		status.Finished = true
+	}
+	write(status)
+}
+
+func init() {
+	core.RegisterRoute(core.Route{
+		Id:      "warm.warm",
+		Method:  http.MethodPost,
+		Path:    "/api/admin/warm/:repoId/:committish",
+		Handler: WarmHandler,
+	})
+
+	schemas.Register("warm.WarmRequest", WarmRequest{})
+	schemas.Register("warm.WarmStatus", WarmStatus{})
+}
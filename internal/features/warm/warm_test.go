@@ -0,0 +1,98 @@
+package warm
+
+import (
+	"github.com/chromy/mylar/internal/features/api"
+	"github.com/julienschmidt/httprouter"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"testing"
+)
+
+func TestParseLayers(t *testing.T) {
+	tests := []struct {
+		input       string
+		expected    []api.WarmLayer
+		expectError bool
+	}{
+		{"length", []api.WarmLayer{{Computation: "length"}}, false},
+		{"length, fileHash:mode", []api.WarmLayer{{Computation: "length"}, {Computation: "fileHash", Agg: "mode"}}, false},
+		{"", nil, true},
+		{":mode", nil, true},
+	}
+
+	for _, test := range tests {
+		actual, err := ParseLayers(test.input)
+		if test.expectError {
+			if err == nil {
+				t.Errorf("ParseLayers(%q): expected error got %v", test.input, actual)
+			}
+			continue
+		}
+		if err != nil {
+			t.Errorf("ParseLayers(%q): unexpected error %v", test.input, err)
+			continue
+		}
+		if !reflect.DeepEqual(actual, test.expected) {
+			t.Errorf("ParseLayers(%q) = %v, expected %v", test.input, actual, test.expected)
+		}
+	}
+}
+
+func TestClampParallelism(t *testing.T) {
+	tests := []struct {
+		parallelism int
+		expected    int
+	}{
+		{0, DefaultParallelism},
+		{-1, DefaultParallelism},
+		{1, 1},
+		{DefaultParallelism, DefaultParallelism},
+		{1000000, DefaultParallelism},
+	}
+
+	for _, test := range tests {
+		if actual := clampParallelism(test.parallelism); actual != test.expected {
+			t.Errorf("clampParallelism(%d) = %d, expected %d", test.parallelism, actual, test.expected)
+		}
+	}
+}
+
+func TestAuthorized(t *testing.T) {
+	r := httptest.NewRequest(http.MethodPost, "/", nil)
+
+	t.Setenv("MYLAR_ADMIN_TOKEN", "")
+	if authorized(r) {
+		t.Errorf("Expected requests to be refused without a token configured")
+	}
+	r.Header.Set("Authorization", "Bearer ")
+	if authorized(r) {
+		t.Errorf("Expected an empty token to be refused without a token configured")
+	}
+	r.Header.Del("Authorization")
+
+	t.Setenv("MYLAR_ADMIN_TOKEN", "secret")
+	if authorized(r) {
+		t.Errorf("Expected request without a token to be refused")
+	}
+
+	r.Header.Set("Authorization", "Bearer wrong")
+	if authorized(r) {
+		t.Errorf("Expected request with the wrong token to be refused")
+	}
+
+	r.Header.Set("Authorization", "Bearer secret")
+	if !authorized(r) {
+		t.Errorf("Expected request with the right token to be allowed")
+	}
+}
+
+func TestWarmHandlerDisabled(t *testing.T) {
+	t.Setenv("MYLAR_ADMIN_TOKEN", "")
+	r := httptest.NewRequest(http.MethodPost, "/api/admin/warm/r/HEAD", nil)
+	w := httptest.NewRecorder()
+	WarmHandler(w, r, httprouter.Params{{Key: "repoId", Value: "r"}, {Key: "committish", Value: "HEAD"}})
+	if w.Code != http.StatusForbidden {
+		t.Errorf("Expected %d without a token configured, got %d", http.StatusForbidden, w.Code)
+	}
+}
@@ -0,0 +1,99 @@
+package mylar
+
+import (
+	"context"
+	"fmt"
+	"github.com/chromy/mylar/internal/features/api"
+	"github.com/chromy/mylar/internal/features/index"
+	"github.com/chromy/mylar/internal/features/repo"
+	"image/png"
+	"log"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+type RenderOptions struct {
+	Repo       string
+	Committish string
+	Layer      string
+	Params     map[string]string
+	Agg        string
+	Channel    int
+	Colormap   string
+	Min        string
+	Max        string
+	Size       int
+	Output     string
+}
+
+// openRepo makes the repo named on the command line available. Repos
+// are either gh:owner:name ids or paths to a local checkout.
+func openRepo(ctx context.Context, arg string) (string, error) {
+	if strings.HasPrefix(arg, "gh:") {
+		if _, err := repo.ResolveRepo(ctx, arg); err != nil {
+			return "", err
+		}
+		return arg, nil
+	}
+
+	path, err := filepath.Abs(arg)
+	if err != nil {
+		return "", err
+	}
+	repoId := "local:" + path
+	if err := repo.AddFromPath(ctx, repoId, path, repo.AddFromPathOptions{Name: filepath.Base(path)}); err != nil {
+		return "", fmt.Errorf("opening %s: %w", path, err)
+	}
+	return repoId, nil
+}
+
+func DoRender(ctx context.Context, options RenderOptions) error {
+	repoId, err := openRepo(ctx, options.Repo)
+	if err != nil {
+		return err
+	}
+
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return err
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, options.Committish)
+	if err != nil {
+		return err
+	}
+
+	layout, err := index.GetLayoutForCommit(ctx, repoId, commit)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("rendering %s at %s (%s) to %s", options.Layer, options.Committish, commit, options.Output)
+	img, err := api.RenderMap(ctx, api.MapRenderOptions{
+		RepoId:      repoId,
+		Commit:      commit,
+		Computation: options.Layer,
+		Params:      options.Params,
+		Agg:         options.Agg,
+		Channel:     options.Channel,
+		Colormap:    options.Colormap,
+		Min:         options.Min,
+		Max:         options.Max,
+		Layout:      layout,
+		Size:        options.Size,
+	})
+	if err != nil {
+		return err
+	}
+
+	f, err := os.Create(options.Output)
+	if err != nil {
+		return err
+	}
+	if err := png.Encode(f, img); err != nil {
+		f.Close()
+		return err
+	}
+	return f.Close()
+}
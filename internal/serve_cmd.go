@@ -44,10 +44,8 @@ func loadInitialRepos(ctx context.Context) {
 	}
 }
 
-func DoServe(ctx context.Context, port uint, memcached string) {
-	initSentry()
-
-	// Initialize cache
+// initCache sets up memcached if given otherwise an in-memory cache.
+func initCache(memcached string) {
 	var cacheImpl cache.Cache
 	if memcached != "" {
 		log.Printf("using memcached at %s", memcached)
@@ -57,7 +55,13 @@ func DoServe(ctx context.Context, port uint, memcached string) {
 		log.Printf("using in-memory cache")
 		cacheImpl = cache.NewMemoryCache()
 	}
-	core.InitCache(cacheImpl)
+	core.InitCache(cache.NewCompressedCache(cacheImpl))
+}
+
+func DoServe(ctx context.Context, port uint, memcached string) {
+	initSentry()
+
+	initCache(memcached)
 
 	router := httprouter.New()
 
@@ -100,6 +100,89 @@ func WorldToLine(world WorldPosition, layout TileLayout) LinePosition {
 	return LinePosition(d)
 }
 
+// TilesPerSide is the number of tiles across the world at lod.
+func (l TileLayout) TilesPerSide(lod int64) int64 {
+	size := int64(LodToSize(int(lod)))
+	if size <= 0 {
+		return 1
+	}
+	return max(l.GridSideLength()/size, 1)
+}
+
+// RootLod is the LOD at which a single tile covers the whole world.
+func (l TileLayout) RootLod() int64 {
+	n := l.GridSideLength()
+	var lod int64
+	for n>>(lod+1) >= constants.TileSize {
+		lod++
+	}
+	return lod
+}
+
+// squareLineRange returns the lines [start, end) which the Hilbert
+// curve passes through in the square of the given side at (x, y) in
+// units of side. Every aligned power of two square is visited by a
+// contiguous run of the curve so any point in the square identifies
+// the run. The range may extend past LineCount. The result is false
+// for squares outside the world and for sides which overflowed.
+func squareLineRange(layout TileLayout, side int64, x int64, y int64) (LinePosition, LinePosition, bool) {
+	if side <= 0 {
+		return 0, 0, false
+	}
+	n := layout.GridSideLength()
+	perSide := max(n/side, 1)
+	if n == 0 || x < 0 || y < 0 || x >= perSide || y >= perSide {
+		return 0, 0, false
+	}
+
+	side = min(side, n)
+	area := side * side
+	start := hilbertIndex(n, x*side, y*side) / area * area
+	return LinePosition(start), LinePosition(start + area), true
+}
+
+// TileLineRange returns the lines [start, end) drawn in a tile. See
+// squareLineRange.
+func TileLineRange(layout TileLayout, lod int64, x int64, y int64) (LinePosition, LinePosition, bool) {
+	return squareLineRange(layout, int64(LodToSize(int(lod))), x, y)
+}
+
+// IsEmptyPixel reports if the pixel (x, y) of the world at lod covers
+// no lines. At LOD 0 a pixel is a single line.
+func IsEmptyPixel(layout TileLayout, lod int64, x int64, y int64) bool {
+	start, _, ok := squareLineRange(layout, int64(1)<<lod, x, y)
+	return !ok || start >= layout.LineCount
+}
+
+// IsBlankTile reports if a tile contains no lines.
+func IsBlankTile(layout TileLayout, lod int64, x int64, y int64) bool {
+	start, _, ok := TileLineRange(layout, lod, x, y)
+	return !ok || start >= layout.LineCount
+}
+
+// CoveredTiles lists the tiles at lod which contain at least one line
+// in the order the Hilbert curve visits them.
+func CoveredTiles(layout TileLayout, lod int64) []TilePosition {
+	tiles := []TilePosition{}
+	n := layout.GridSideLength()
+	if n == 0 {
+		return tiles
+	}
+
+	tileSize := int64(LodToSize(int(lod)))
+	side := min(tileSize, n)
+	area := side * side
+	for start := int64(0); start < int64(layout.LineCount); start += area {
+		world := LineToWorld(LinePosition(start), layout)
+		tiles = append(tiles, TilePosition{
+			Lod:   lod,
+			TileX: world.X / tileSize,
+			TileY: world.Y / tileSize,
+		})
+	}
+	return tiles
+}
+
 // rot rotates and flips the quadrant for the Hilbert curve
 func rot(n int64, x, y *int64, rx, ry int64) {
 	if ry == 0 {
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"github.com/chromy/mylar/internal/constants"
+	"testing"
+)
+
+func TestTileLineRange(t *testing.T) {
+	layout := TileLayout{LineCount: 5000}
+	// 5000 lines need a 128x128 world so four 64x64 tiles at LOD 0.
+	if n := layout.GridSideLength(); n != 128 {
+		t.Fatalf("Expected grid side 128 got %d", n)
+	}
+
+	seen := make(map[LinePosition]bool)
+	for y := int64(0); y < 2; y++ {
+		for x := int64(0); x < 2; x++ {
+			start, end, ok := TileLineRange(layout, 0, x, y)
+			if !ok {
+				t.Fatalf("Expected tile (%d, %d) to be inside the world", x, y)
+			}
+			if end-start != constants.TileSize*constants.TileSize {
+				t.Errorf("Expected tile (%d, %d) to cover a full tile of lines got [%d, %d)", x, y, start, end)
+			}
+			// Every line in the range must map back into the tile.
+			for line := start; line < end; line++ {
+				tile := WorldToTile(LineToWorld(line, layout), layout)
+				if tile.TileX != x || tile.TileY != y {
+					t.Fatalf("Line %d in range of tile (%d, %d) maps to (%d, %d)", line, x, y, tile.TileX, tile.TileY)
+				}
+			}
+			seen[start] = true
+		}
+	}
+	if len(seen) != 4 {
+		t.Errorf("Expected four distinct ranges got %v", seen)
+	}
+
+	if _, _, ok := TileLineRange(layout, 0, 2, 0); ok {
+		t.Errorf("Expected tile outside the world to be rejected")
+	}
+	if start, end, ok := TileLineRange(layout, 3, 0, 0); !ok || start != 0 || end != 128*128 {
+		t.Errorf("Expected root tile to cover the whole world got [%d, %d) %v", start, end, ok)
+	}
+}
+
+func TestIsBlankTileAndCoveredTiles(t *testing.T) {
+	tests := []struct {
+		name      string
+		lineCount LinePosition
+		lod       int64
+		covered   int
+	}{
+		{"empty", 0, 0, 0},
+		{"single line", 1, 0, 1},
+		{"one tile", 4096, 0, 1},
+		{"partial second tile", 4097, 0, 2},
+		{"three of four", 10000, 0, 3},
+		{"macro tile", 10000, 1, 1},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			layout := TileLayout{LineCount: test.lineCount}
+			covered := CoveredTiles(layout, test.lod)
+			if len(covered) != test.covered {
+				t.Fatalf("Expected %d covered tiles got %v", test.covered, covered)
+			}
+
+			isCovered := make(map[[2]int64]bool)
+			for _, tile := range covered {
+				isCovered[[2]int64{tile.TileX, tile.TileY}] = true
+			}
+
+			side := layout.TilesPerSide(test.lod)
+			for y := int64(0); y < side; y++ {
+				for x := int64(0); x < side; x++ {
+					if IsBlankTile(layout, test.lod, x, y) == isCovered[[2]int64{x, y}] {
+						t.Errorf("IsBlankTile(%d, %d) disagrees with CoveredTiles", x, y)
+					}
+				}
+			}
+		})
+	}
+}
+
+func TestIsEmptyPixel(t *testing.T) {
+	layout := TileLayout{LineCount: 10}
+	n := layout.GridSideLength()
+
+	for y := int64(0); y < n; y++ {
+		for x := int64(0); x < n; x++ {
+			line := WorldToLine(WorldPosition{X: x, Y: y}, layout)
+			if IsEmptyPixel(layout, 0, x, y) != (line >= layout.LineCount) {
+				t.Errorf("IsEmptyPixel(0, %d, %d) wrong for line %d", x, y, line)
+			}
+		}
+	}
+
+	// At LOD 1 each pixel covers four lines.
+	if IsEmptyPixel(layout, 1, 0, 0) {
+		t.Errorf("Expected first LOD 1 pixel to have lines")
+	}
+	empty := 0
+	for y := int64(0); y < n/2; y++ {
+		for x := int64(0); x < n/2; x++ {
+			if IsEmptyPixel(layout, 1, x, y) {
+				empty++
+			}
+		}
+	}
+	// 16 lines in 4 pixels, lines 0..9 cover the first three.
+	if empty != 1 {
+		t.Errorf("Expected one empty LOD 1 pixel got %d", empty)
+	}
+
+	if !IsEmptyPixel(layout, 0, n, 0) {
+		t.Errorf("Expected pixel outside the world to be empty")
+	}
+}
+
+func TestRootLodAndTilesPerSide(t *testing.T) {
+	tests := []struct {
+		lineCount LinePosition
+		root      int64
+		tiles     []int64
+	}{
+		{200, 0, []int64{1}},
+		{64 * 64, 0, []int64{1}},
+		{128 * 128, 1, []int64{2, 1}},
+		{1000 * 1000, 4, []int64{16, 8, 4, 2, 1}},
+	}
+
+	for _, test := range tests {
+		layout := TileLayout{LineCount: test.lineCount}
+		if actual := layout.RootLod(); actual != test.root {
+			t.Errorf("RootLod() for %d lines = %d, expected %d", test.lineCount, actual, test.root)
+		}
+		for lod, expected := range test.tiles {
+			if actual := layout.TilesPerSide(int64(lod)); actual != expected {
+				t.Errorf("TilesPerSide(%d) for %d lines = %d, expected %d", lod, test.lineCount, actual, expected)
+			}
+		}
+	}
+}
+
+func TestInvalidLods(t *testing.T) {
+	layout := TileLayout{LineCount: 5000}
+	for _, lod := range []int64{-1, 57, 58, 100} {
+		if !IsBlankTile(layout, lod, 0, 0) {
+			t.Errorf("Expected LOD %d to be blank", lod)
+		}
+		if _, _, ok := TileLineRange(layout, lod, 0, 0); ok {
+			t.Errorf("Expected LOD %d to have no line range", lod)
+		}
+		if side := layout.TilesPerSide(lod); side != 1 {
+			t.Errorf("Expected one tile across at LOD %d got %d", lod, side)
+		}
+	}
+}
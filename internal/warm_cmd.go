@@ -0,0 +1,57 @@
+package mylar
+
+import (
+	"context"
+	"github.com/chromy/mylar/internal/features/api"
+	"github.com/chromy/mylar/internal/features/repo"
+	"github.com/chromy/mylar/internal/features/warm"
+	"log"
+)
+
+type WarmOptions struct {
+	Repo        string
+	Committish  string
+	Layers      string
+	Parallelism int
+}
+
+func DoWarm(ctx context.Context, options WarmOptions) error {
+	layers := warm.DefaultLayers
+	if options.Layers != "" {
+		parsed, err := warm.ParseLayers(options.Layers)
+		if err != nil {
+			return err
+		}
+		layers = parsed
+	}
+
+	repoId, err := openRepo(ctx, options.Repo)
+	if err != nil {
+		return err
+	}
+
+	repository, err := repo.ResolveRepo(ctx, repoId)
+	if err != nil {
+		return err
+	}
+
+	commit, err := repo.ResolveCommittishToHash(repository, options.Committish)
+	if err != nil {
+		return err
+	}
+
+	log.Printf("warming %d layers at %s (%s)", len(layers), options.Committish, commit)
+	lastPercent := -1
+	err = warm.Warm(ctx, repoId, commit, layers, options.Parallelism, func(progress api.WarmProgress) {
+		if percent := warm.ProgressPercent(progress); percent != lastPercent {
+			lastPercent = percent
+			log.Printf("%3d%% %d/%d tiles (%s LOD %d)", percent, progress.Done, progress.Total, progress.Layer, progress.Lod)
+		}
+	})
+	if err != nil {
+		return err
+	}
+
+	log.Printf("warmed %s", commit)
+	return nil
+}
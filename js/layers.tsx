@@ -22,6 +22,21 @@ export const LAYER_OPTIONS: LayerType[] = [
     composite: "hash|int32ToUnit|rainbow|oklchToSrgb|toByteX3",
     aggregation: "mode",
   },
+  {
+    kind: "age",
+    composite: "daysAgo|0|max|730|min|730|div|rainbow|oklchToSrgb|toByteX3",
+    aggregation: "mean:nonzero",
+  },
+  {
+    kind: "author",
+    composite: "1|swap|1|swap|hash|360|mod|oklchToSrgb|toByteX3",
+    aggregation: "mode:nonzero",
+  },
+  {
+    kind: "churn",
+    composite: "50|min|50|div|rainbow|oklchToSrgb|toByteX3",
+    aggregation: "max",
+  },
 ];
 
 export const DEFAULT_LAYER = LAYER_OPTIONS[0]!;
@@ -32,4 +47,7 @@ export const LAYER_LABELS: Record<string, string> = {
   offset: "Line Offset",
   fileHash: "File Hash",
   fileExtension: "File Type",
+  age: "Line Age",
+  author: "Author",
+  churn: "Churn",
 };
@@ -2,6 +2,61 @@ import { z } from "zod";
 
 export const TILE_SIZE = 64;
 
+export const HistogramBinSchema = z.object({
+  min: z.number(),
+  max: z.number(),
+  count: z.number(),
+});
+export type HistogramBin = z.infer<typeof HistogramBinSchema>;
+
+export const LayerStatsSchema = z.object({
+  count: z.number(),
+  min: z.number(),
+  max: z.number(),
+  mean: z.number(),
+  histogram: HistogramBinSchema.array().nullable(),
+});
+export type LayerStats = z.infer<typeof LayerStatsSchema>;
+
+export const TileBatchItemSchema = z.object({
+  computation: z.string(),
+  lod: z.number(),
+  x: z.number(),
+  y: z.number(),
+  agg: z.string().optional(),
+  params: z.record(z.string(), z.string()).optional(),
+});
+export type TileBatchItem = z.infer<typeof TileBatchItemSchema>;
+
+export const TileBatchRequestSchema = z.object({
+  tiles: TileBatchItemSchema.array().nullable(),
+});
+export type TileBatchRequest = z.infer<typeof TileBatchRequestSchema>;
+
+export const TileParamSchema = z.object({
+  name: z.string(),
+  type: z.string(),
+  default: z.string().optional(),
+  required: z.boolean().optional(),
+});
+export type TileParam = z.infer<typeof TileParamSchema>;
+
+export const TileChannelSchema = z.object({
+  name: z.string(),
+  agg: z.string().optional(),
+  categorical: z.boolean().optional(),
+});
+export type TileChannel = z.infer<typeof TileChannelSchema>;
+
+export const TileComputationInfoSchema = z.object({
+  id: z.string(),
+  params: TileParamSchema.array().nullable(),
+  channels: TileChannelSchema.array().nullable(),
+  agg: z.string().optional(),
+  categorical: z.boolean().optional(),
+});
+export type TileComputationInfo = z.infer<typeof TileComputationInfoSchema>;
+
 export const TileMetadataSchema = z.object({
   x: z.number(),
   y: z.number(),
@@ -9,6 +64,69 @@ export const TileMetadataSchema = z.object({
 });
 export type TileMetadata = z.infer<typeof TileMetadataSchema>;
 
+export const WarmLayerSchema = z.object({
+  computation: z.string(),
+  agg: z.string().optional(),
+  params: z.record(z.string(), z.string()).optional(),
+});
+export type WarmLayer = z.infer<typeof WarmLayerSchema>;
+
+export const WarmProgressSchema = z.object({
+  layer: z.string(),
+  lod: z.number(),
+  done: z.number(),
+  total: z.number(),
+});
+export type WarmProgress = z.infer<typeof WarmProgressSchema>;
+
+export const AuthorInfoSchema = z.object({
+  id: z.number(),
+  name: z.string(),
+  email: z.string(),
+});
+export type AuthorInfo = z.infer<typeof AuthorInfoSchema>;
+
+export const AuthorsResponseSchema = z.object({
+  authors: AuthorInfoSchema.array().nullable(),
+});
+export type AuthorsResponse = z.infer<typeof AuthorsResponseSchema>;
+
+export const BlameLineSchema = z.object({
+  author: z.string(),
+  authorName: z.string(),
+  hash: z.string(),
+  date: z.coerce.date(),
+  text: z.string(),
+});
+export type BlameLine = z.infer<typeof BlameLineSchema>;
+
+export const BlameResultSchema = z.object({
+  path: z.string(),
+  paths: z.string().array().nullable(),
+  lines: BlameLineSchema.array().nullable(),
+});
+export type BlameResult = z.infer<typeof BlameResultSchema>;
+
+export const DeletedRegionSchema = z.object({
+  path: z.string(),
+  baseLine: z.number(),
+  lineCount: z.number(),
+  line: z.number(),
+  file: z.boolean(),
+});
+export type DeletedRegion = z.infer<typeof DeletedRegionSchema>;
+
+export const CommitDiffSchema = z.object({
+  base: z.string(),
+  commit: z.string(),
+  unchanged: z.number(),
+  modified: z.number(),
+  added: z.number(),
+  deleted: z.number(),
+  deletedRegions: DeletedRegionSchema.array().nullable(),
+});
+export type CommitDiff = z.infer<typeof CommitDiffSchema>;
+
 export const IndexEntrySchema = z.object({
   path: z.string(),
   lineOffset: z.number(),
@@ -51,6 +169,88 @@ export const LineLengthSchema = z.object({
 });
 export type LineLength = z.infer<typeof LineLengthSchema>;
 
+export const SearchMatchSchema = z.object({
+  path: z.string(),
+  line: z.number(),
+  lineOffset: z.number(),
+  text: z.string(),
+  worldPosition: WorldPositionSchema,
+  tilePosition: TilePositionSchema,
+});
+export type SearchMatch = z.infer<typeof SearchMatchSchema>;
+
+export const SearchResponseSchema = z.object({
+  query: z.string(),
+  regex: z.boolean(),
+  matches: SearchMatchSchema.array().nullable(),
+  truncated: z.boolean(),
+});
+export type SearchResponse = z.infer<typeof SearchResponseSchema>;
+
+export const TileCoordSchema = z.object({
+  x: z.number(),
+  y: z.number(),
+});
+export type TileCoord = z.infer<typeof TileCoordSchema>;
+
+export const TileCoverageResponseSchema = z.object({
+  lod: z.number(),
+  tilesPerSide: z.number(),
+  tiles: TileCoordSchema.array().nullable(),
+});
+export type TileCoverageResponse = z.infer<typeof TileCoverageResponseSchema>;
+
+export const TreeStatsSchema = z.object({
+  lines: z.number(),
+  files: z.number(),
+  extensions: z.record(z.string(), z.number()).nullable(),
+});
+export type TreeStats = z.infer<typeof TreeStatsSchema>;
+
+export const TimelinePointSchema = z.object({
+  commit: z.string(),
+  date: z.coerce.date(),
+  tag: z.string().optional(),
+  stats: TreeStatsSchema,
+});
+export type TimelinePoint = z.infer<typeof TimelinePointSchema>;
+
+export const TimelineResponseSchema = z.object({
+  points: TimelinePointSchema.array().nullable(),
+});
+export type TimelineResponse = z.infer<typeof TimelineResponseSchema>;
+
+export const BranchInfoSchema = z.object({
+  name: z.string(),
+  commit: z.string(),
+  date: z.coerce.date(),
+  default: z.boolean(),
+});
+export type BranchInfo = z.infer<typeof BranchInfoSchema>;
+
+export const BranchListResponseSchema = z.object({
+  branches: BranchInfoSchema.array().nullable(),
+});
+export type BranchListResponse = z.infer<typeof BranchListResponseSchema>;
+
+export const LogEntrySchema = z.object({
+  hash: z.string(),
+  author: z.string(),
+  authorName: z.string(),
+  date: z.coerce.date(),
+  message: z.string(),
+  parents: z.string().array().nullable(),
+});
+export type LogEntry = z.infer<typeof LogEntrySchema>;
+
+export const LogResponseSchema = z.object({
+  commit: z.string(),
+  offset: z.number(),
+  commits: LogEntrySchema.array().nullable(),
+  hasMore: z.boolean(),
+});
+export type LogResponse = z.infer<typeof LogResponseSchema>;
+
 export const RepoInfoSchema = z.object({
   id: z.string(),
   owner: z.string().optional(),
@@ -117,3 +317,17 @@ export const VarzResponseSchema = z.object({
   memory: MemoryStatsSchema,
 });
 export type VarzResponse = z.infer<typeof VarzResponseSchema>;
+
+export const WarmRequestSchema = z.object({
+  layers: WarmLayerSchema.array().nullable(),
+  parallelism: z.number(),
+});
+export type WarmRequest = z.infer<typeof WarmRequestSchema>;
+
+export const WarmStatusSchema = z.object({
+  commit: z.string(),
+  progress: WarmProgressSchema,
+  finished: z.boolean(),
+  error: z.string().optional(),
+});
+export type WarmStatus = z.infer<typeof WarmStatusSchema>;
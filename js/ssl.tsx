@@ -74,6 +74,8 @@ export const mod = binOp((a, b) => a % b);
 export const div = binOp((a, b) => a / b);
 export const toByte = unOp(floatToByte);
 export const int32ToUnit = unOp(n => (n + 2147483648) / 4294967295);
+// daysAgo turns a day counted from the Unix epoch into days before today.
+export const daysAgo = unOp(n => Math.floor(Date.now() / 86400000) - n);
 export const hash = unOp(n => {
   n ^= n >>> 16;
   n = Math.imul(n, 0x85ebca6b);
@@ -125,6 +127,7 @@ export const ops: { [key: string]: Op } = {
   toByte,
   toByteX3: mapTopThree(floatToByte),
   int32ToUnit,
+  daysAgo,
   max,
   oklchToSrgb,
   rainbow,
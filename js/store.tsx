@@ -19,13 +19,13 @@ export interface TileRequest {
 
 interface TileData {
   metadata: TileMetadata;
-  data: number[];
+  data: ArrayLike<number>;
 }
 
 function compositeTile(
   program: string,
   size: number,
-  tiles: number[][],
+  tiles: ArrayLike<number>[],
   buffer: Uint8ClampedArray,
 ): void {
   const instructions = compile(program);
@@ -59,7 +59,7 @@ function compositeTile(
 
 async function createTileBitmap(
   composite: string,
-  tileData: number[],
+  tileData: ArrayLike<number>,
 ): Promise<ImageBitmap> {
   const buffer = new Uint8ClampedArray(TILE_SIZE * TILE_SIZE * 4);
 
@@ -77,49 +77,69 @@ async function createTileBitmap(
   });
 }
 
-async function fetchTile(url: string, signal: AbortSignal): Promise<TileData> {
-  const response = await fetch(url, { signal });
+// Binary tile format, see binaryTileHeader in api.go.
+const TILE_MAGIC = "MYLT";
+const TILE_VERSION = 1;
+const TILE_HEADER_SIZE = 24;
 
-  if (!response.ok) {
-    throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+export function parseBinaryTile(buffer: ArrayBuffer): TileData {
+  if (buffer.byteLength < TILE_HEADER_SIZE) {
+    throw new Error(`Tile response too short: ${buffer.byteLength} bytes`);
   }
 
-  const responseText = await response.text();
-  const lines = responseText.split("\n");
-
-  if (lines.length < 2) {
-    throw new Error(
-      "Invalid response format: expected metadata line and data line",
-    );
+  const view = new DataView(buffer);
+  const magic = String.fromCharCode(
+    view.getUint8(0),
+    view.getUint8(1),
+    view.getUint8(2),
+    view.getUint8(3),
+  );
+  if (magic !== TILE_MAGIC) {
+    throw new Error(`Invalid tile magic: ${magic}`);
   }
 
-  const rawMetadata = lines[0]!;
-  const rawData = lines[1]!;
+  const version = view.getUint16(4, true);
+  if (version !== TILE_VERSION) {
+    throw new Error(`Unsupported tile version: ${version}`);
+  }
 
   let metadata: TileMetadata;
   try {
-    metadata = TileMetadataSchema.parse(JSON.parse(rawMetadata));
+    metadata = TileMetadataSchema.parse({
+      lod: view.getInt32(8, true),
+      x: view.getInt32(12, true),
+      y: view.getInt32(16, true),
+    });
   } catch (error) {
     throw new Error(`Failed to parse tile metadata: ${error}`);
   }
 
-  // Parse tile data from second line
-  let data: number[];
-  try {
-    data = JSON.parse(rawData);
-    if (!Array.isArray(data)) {
-      throw new Error("Tile data is not an array");
-    }
-  } catch (error) {
-    throw new Error(`Failed to parse tile data: ${error}`);
+  const length = view.getUint32(20, true);
+  if (buffer.byteLength !== TILE_HEADER_SIZE + length * 4) {
+    throw new Error(
+      `Tile length mismatch: header says ${length} pixels, got ${buffer.byteLength} bytes`,
+    );
   }
 
   return {
     metadata,
-    data,
+    data: new Int32Array(buffer, TILE_HEADER_SIZE, length),
   };
 }
 
+async function fetchTile(url: string, signal: AbortSignal): Promise<TileData> {
+  const response = await fetch(url, {
+    signal,
+    headers: { Accept: "application/octet-stream" },
+  });
+
+  if (!response.ok) {
+    throw new Error(`HTTP ${response.status}: ${response.statusText}`);
+  }
+
+  return parseBinaryTile(await response.arrayBuffer());
+}
+
 export class TileStore {
   private readonly maxLiveRequests: number;
 